@@ -0,0 +1,313 @@
+// Package certs loads the certificate the app serves https with. For LAN use (the stall tablet talking
+// to a laptop) it runs its own small CA: the tablet trusts ca.pem once, and the CA can only vouch for
+// this machine's names and the TLS_HOSTS ones, so its key is no use for impersonating anything else.
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
+)
+
+const (
+	// the most Apple devices accept for a server certificate, the CA isn't held to it
+	leafValidity = 825 * 24 * time.Hour
+	caValidity   = 10 * 365 * 24 * time.Hour
+	// a certificate this close to expiring is made again, a provided one gets a warning
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// The certificate for cfg.TLS.Mode, files or self-signed
+func Load(cfg *config.Config) (tls.Certificate, error) {
+	if cfg.TLS.Mode == "self-signed" {
+		return selfSigned(cfg.TLS.CertFile, cfg.TLS.KeyFile, localHosts(cfg.TLS.Hosts))
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to load TLS certificate - %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to parse TLS certificate - %w", err)
+	}
+	cert.Leaf = leaf
+	if time.Until(leaf.NotAfter) < renewBefore {
+		logger.Warn("TLS certificate expires soon", "file", cfg.TLS.CertFile, "expires", leaf.NotAfter)
+	}
+	logger.Info("Loaded TLS certificate", "file", cfg.TLS.CertFile, "subject", leaf.Subject.CommonName,
+		"expires", leaf.NotAfter, "sha256", fingerprint(leaf))
+	return cert, nil
+}
+
+// CA files next to the certificate, ca.pem is the one to install on the tablet
+func caFiles(certFile string) (string, string) {
+	dir := filepath.Dir(certFile)
+	return filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+}
+
+// Reuses the CA and certificate on disk while they're good for every host. A new certificate is issued from the
+// same CA when it's about to expire, only a change to the hosts (TLS_HOSTS, hostname) needs a new CA on the tablet.
+func selfSigned(certFile string, keyFile string, hosts []string) (tls.Certificate, error) {
+	caFile, caKeyFile := caFiles(certFile)
+	ca, caKey, err := loadCA(caFile, caKeyFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		ca = nil
+	case err != nil:
+		logger.Warn("Unable to load local CA, making a new one", "file", caFile, "err", err)
+		ca = nil
+	case time.Until(ca.NotAfter) < renewBefore:
+		logger.Info("Local CA expires soon, making a new one", "expires", ca.NotAfter)
+		ca = nil
+	default:
+		if missing := unconstrained(ca, hosts); len(missing) > 0 {
+			logger.Info("Local CA doesn't cover every host, making a new one", "missing", strings.Join(missing, ","))
+			ca = nil
+		}
+	}
+
+	if ca == nil {
+		if ca, caKey, err = newCA(caFile, caKeyFile, hosts); err != nil {
+			return tls.Certificate{}, fmt.Errorf("Unable to make local CA - %w", err)
+		}
+		// the tablet has to trust this one (again)
+		logger.Warn("Made local CA, install it on the devices that use the app", "file", caFile,
+			"hosts", strings.Join(hosts, ","), "expires", ca.NotAfter, "sha256", fingerprint(ca))
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && leaf.CheckSignatureFrom(ca) == nil && time.Until(leaf.NotAfter) >= renewBefore && len(uncovered(leaf, hosts)) == 0 {
+			cert.Leaf = leaf
+			cert.Certificate = append(cert.Certificate[:1], ca.Raw)
+			logger.Info("Loaded certificate from local CA", "file", certFile, "expires", leaf.NotAfter, "ca_sha256", fingerprint(ca))
+			return cert, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("Unable to load certificate, issuing a new one", "file", certFile, "err", err)
+	}
+
+	cert, err = issue(certFile, keyFile, ca, caKey, hosts)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Unable to issue TLS certificate - %w", err)
+	}
+	logger.Info("Issued certificate from local CA", "file", certFile, "hosts", strings.Join(hosts, ","),
+		"expires", cert.Leaf.NotAfter, "ca_sha256", fingerprint(ca))
+	return cert, nil
+}
+
+func loadCA(caFile string, caKeyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := tls.LoadX509KeyPair(caFile, caKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !ca.IsCA {
+		return nil, nil, errors.New("not a CA certificate and key")
+	}
+	return ca, key, nil
+}
+
+// A CA that can only sign for hosts (name constraints), kept at caFile and caKeyFile
+func newCA(caFile string, caKeyFile string, hosts []string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "MIMS local CA", Organization: []string{"MIMS"}},
+		// a little slack for a tablet whose clock is behind
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		// it signs the server certificate and nothing under it
+		MaxPathLenZero:              true,
+		PermittedDNSDomainsCritical: true,
+	}
+	dnsNames, ips := splitHosts(hosts)
+	template.PermittedDNSDomains = dnsNames
+	for _, ip := range ips {
+		bits := 8 * len(ip)
+		template.PermittedIPRanges = append(template.PermittedIPRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePair(caFile, caKeyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// A server certificate for hosts signed by ca, written to certFile and keyFile
+func issue(certFile string, keyFile string, ca *x509.Certificate, caKey crypto.Signer, hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := serialNumber()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"MIMS"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(leafValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	template.DNSNames, template.IPAddresses = splitHosts(hosts)
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writePair(certFile, keyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der, ca.Raw}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func writePair(certFile string, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// The names that stay put: localhost, the hostname (and its mDNS .local name) and the ones from TLS_HOSTS.
+// Interface addresses are left out on purpose, they come and go (DHCP, IPv6 privacy addresses, VPNs, docker)
+// and each change would mean a new CA on the tablet. Put the laptop's LAN address in TLS_HOSTS to use it.
+func localHosts(extra string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+		if !strings.Contains(name, ".") {
+			hosts = append(hosts, name+".local")
+		}
+	}
+	for _, h := range strings.Split(extra, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	seen := make(map[string]bool, len(hosts))
+	unique := hosts[:0]
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if !seen[h] {
+			seen[h] = true
+			unique = append(unique, h)
+		}
+	}
+	return unique
+}
+
+func splitHosts(hosts []string) (dnsNames []string, ips []net.IP) {
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, h)
+		}
+	}
+	return dnsNames, ips
+}
+
+// The hosts leaf isn't valid for
+func uncovered(leaf *x509.Certificate, hosts []string) []string {
+	var missing []string
+	for _, h := range hosts {
+		if leaf.VerifyHostname(h) != nil {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+// The hosts ca's name constraints don't allow it to sign for
+func unconstrained(ca *x509.Certificate, hosts []string) []string {
+	var missing []string
+	for _, h := range hosts {
+		allowed := false
+		if ip := net.ParseIP(h); ip != nil {
+			for _, r := range ca.PermittedIPRanges {
+				allowed = allowed || r.Contains(ip)
+			}
+		} else {
+			for _, d := range ca.PermittedDNSDomains {
+				allowed = allowed || h == d || strings.HasSuffix(h, "."+d)
+			}
+		}
+		if !allowed {
+			missing = append(missing, h)
+		}
+	}
+	return missing
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
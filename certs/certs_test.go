@@ -0,0 +1,134 @@
+package certs
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testHosts = []string{"localhost", "127.0.0.1", "::1", "stall-laptop", "stall-laptop.local", "192.168.1.20"}
+
+func TestUncovered(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := selfSigned(certFile, keyFile, testHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		hosts []string
+		want  []string
+	}{
+		{"every host it was made for", testHosts, nil},
+		{"some of them", []string{"localhost", "192.168.1.20"}, nil},
+		{"new address", []string{"localhost", "192.168.1.21"}, []string{"192.168.1.21"}},
+		{"other name", []string{"example.com", "stall-laptop"}, []string{"example.com"}},
+		{"subdomain", []string{"www.stall-laptop.local"}, []string{"www.stall-laptop.local"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := uncovered(cert.Leaf, tt.hosts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uncovered = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCAConstraints(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := selfSigned(certFile, keyFile, testHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, caKey, err := loadCA(caFiles(certFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.IsCA {
+		t.Error("server certificate is a CA")
+	}
+	if got := unconstrained(ca, append(testHosts, "sub.stall-laptop")); got != nil {
+		t.Errorf("unconstrained = %v, want none", got)
+	}
+	if got := unconstrained(ca, []string{"example.com", "10.0.0.1"}); !reflect.DeepEqual(got, []string{"example.com", "10.0.0.1"}) {
+		t.Errorf("unconstrained = %v, want example.com and 10.0.0.1", got)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"localhost", true},
+		{"192.168.1.20", true},
+		{"stall-laptop.local", true},
+		// a stolen CA key can't vouch for anything else
+		{"example.com", false},
+		{"192.168.1.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			// issued without looking at the constraints, the client is what enforces them
+			forged, err := issue(filepath.Join(dir, "forged.pem"), filepath.Join(dir, "forged-key.pem"), ca, caKey, []string{tt.host})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = forged.Leaf.Verify(x509.VerifyOptions{DNSName: tt.host, Roots: roots, CurrentTime: time.Now()})
+			if (err == nil) != tt.ok {
+				t.Errorf("verify %s: err = %v, want ok %v", tt.host, err, tt.ok)
+			}
+		})
+	}
+}
+
+// The CA stays put unless the hosts change, the certificate is issued again from it when it's gone or stale
+func TestSelfSignedReuse(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	caFile, _ := caFiles(certFile)
+
+	first, err := selfSigned(certFile, keyFile, testHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstCA, _ := os.ReadFile(caFile)
+
+	tests := []struct {
+		name      string
+		prepare   func()
+		hosts     []string
+		wantNewCA bool
+		wantLeaf  bool // the same certificate as the first one
+	}{
+		{"restart", func() {}, testHosts, false, true},
+		{"fewer hosts", func() {}, testHosts[:3], false, true},
+		{"certificate deleted", func() { os.Remove(certFile) }, testHosts, false, false},
+		{"host added", func() {}, append(testHosts, "mims.example"), true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.prepare()
+			cert, err := selfSigned(certFile, keyFile, tt.hosts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ca, _ := os.ReadFile(caFile)
+			if newCA := string(ca) != string(firstCA); newCA != tt.wantNewCA {
+				t.Errorf("new CA = %v, want %v", newCA, tt.wantNewCA)
+			}
+			if same := cert.Leaf.Equal(first.Leaf); same != tt.wantLeaf {
+				t.Errorf("same certificate = %v, want %v", same, tt.wantLeaf)
+			}
+			if got := uncovered(cert.Leaf, tt.hosts); got != nil {
+				t.Errorf("certificate doesn't cover %v", got)
+			}
+		})
+	}
+}
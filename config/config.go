@@ -1,18 +1,470 @@
+// Package config loads the app's settings once at startup: defaults, then an optional YAML file
+// (CONFIG_FILE, config.yaml when it exists), then .env and the environment, which win.
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"time"
 
+	"github.com/CRTOsp3ck/mims-app/logger"
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
-// Config func to get env value
-func Config(key string) string {
-	// load .env file
-	err := godotenv.Load(".env")
+type Config struct {
+	Port int `yaml:"port"`
+	// how long in-flight requests get to finish on SIGINT/SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+	// logfmt or json
+	LogFormat string `yaml:"log_format"`
+	// bearer token for /metrics and /health/datastore, without one they only answer requests from this machine
+	// (and nothing behind a proxy, CookieSecure with TLS off)
+	MetricsToken string `yaml:"metrics_token"`
+	// the YAML file the config came from, empty when there was none
+	File string `yaml:"-"`
+
+	// "http" (mims-datastore at APIServerAddr) or "embedded" (local BoltDB file at StorePath)
+	Store                 string `yaml:"store"`
+	APIServerAddr         string `yaml:"api_server_addr"`
+	StorePath             string `yaml:"store_path"`
+	EmbeddedAdminIdentity string `yaml:"embedded_admin_identity"`
+	EmbeddedAdminPassword string `yaml:"embedded_admin_password"`
+
+	TargetsFile string `yaml:"targets_file"`
+	// roles, disabled accounts and pending password changes of the datastore's users, http store only
+	RolesFile   string `yaml:"roles_file"`
+	SessionPath string `yaml:"session_path"`
+	// how long a login lasts, and with "remember me" how long the browser can come back without logging in
+	SessionLength    time.Duration `yaml:"session_length"`
+	RememberMeLength time.Duration `yaml:"remember_me_length"`
+	// auth cookies only go over https, turn on when a proxy in front of the app does TLS (always on with TLS.Mode)
+	CookieSecure  bool          `yaml:"cookie_secure"`
+	OutboxPath    string        `yaml:"outbox_path"`
+	RollupPath    string        `yaml:"rollup_path"`
+	RollupRefresh time.Duration `yaml:"rollup_refresh"`
+	// 0 turns the sales cache off
+	SalesCacheTTL time.Duration `yaml:"sales_cache_ttl"`
+
+	Datastore Datastore `yaml:"datastore"`
+	Login     Login     `yaml:"login"`
+	Headers   Headers   `yaml:"headers"`
+	TLS       TLS       `yaml:"tls"`
+}
+
+// Calls to mims-datastore
+type Datastore struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	TimeoutLogin time.Duration `yaml:"timeout_login"`
+	TimeoutAuth  time.Duration `yaml:"timeout_auth"`
+	TimeoutFind  time.Duration `yaml:"timeout_find"`
+	TimeoutSale  time.Duration `yaml:"timeout_sale"`
+	// reads (auth status, find sales) are tried this many more times
+	Retries         int           `yaml:"retries"`
+	RetryDelay      time.Duration `yaml:"retry_delay"`
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+	// how long a confirmed login is trusted without asking, and while the datastore is down
+	AuthCache time.Duration `yaml:"auth_cache"`
+	AuthGrace time.Duration `yaml:"auth_grace"`
+	// how long the datastore's tokens last, for a datastore that can't renew them (no /auth/refresh).
+	// A remembered login can't outlive its token, so REMEMBER_ME_LENGTH is cut down to this. 0 when unknown.
+	TokenLength time.Duration `yaml:"token_length"`
+}
+
+// Brute-force protection on /auth/login
+type Login struct {
+	// attempts from one IP address within IPWindow
+	IPLimit  int           `yaml:"ip_limit"`
+	IPWindow time.Duration `yaml:"ip_window"`
+	// attempts at one identity within IdentityWindow, from anywhere
+	IdentityLimit  int           `yaml:"identity_limit"`
+	IdentityWindow time.Duration `yaml:"identity_window"`
+	// this many wrong passwords in a row lock the identity for Lockout after the last one
+	LockoutFailures int           `yaml:"lockout_failures"`
+	Lockout         time.Duration `yaml:"lockout"`
+	// how long login attempts are kept for the Users page
+	AttemptRetention time.Duration `yaml:"attempt_retention"`
+}
+
+// Security headers on every response
+type Headers struct {
+	// enforce, report-only (the browser only reports what it would block, for trying a change out) or off
+	CSP string `yaml:"csp"`
+	// where browsers send CSP violation reports, none when empty
+	CSPReportURI string `yaml:"csp_report_uri"`
+	// DENY or SAMEORIGIN, the CSP's frame-ancestors follows it
+	FrameOptions   string `yaml:"frame_options"`
+	ReferrerPolicy string `yaml:"referrer_policy"`
+	// Strict-Transport-Security on https requests, 0 leaves it off
+	HSTSMaxAge time.Duration `yaml:"hsts_max_age"`
+}
+
+// HTTPS served by the app itself
+type TLS struct {
+	// off, files (CertFile and KeyFile, from a CA or your own) or self-signed (issued to CertFile and KeyFile by
+	// a local CA kept next to them in ca.pem, for LAN use)
+	Mode     string `yaml:"mode"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// more names and addresses for the self-signed certificate, comma separated, like the LAN address the
+	// tablet uses. It always covers localhost and the hostname (and hostname.local)
+	Hosts string `yaml:"hosts"`
+	// plain http port that redirects to https on Port, 0 for none
+	RedirectPort int `yaml:"redirect_port"`
+}
+
+func defaults() *Config {
+	return &Config{
+		Port:             3000,
+		ShutdownTimeout:  10 * time.Second,
+		LogLevel:         "info",
+		LogFormat:        "logfmt",
+		Store:            "http",
+		StorePath:        filepath.Join("data", "mims.db"),
+		TargetsFile:      filepath.Join("data", "targets.json"),
+		RolesFile:        filepath.Join("data", "roles.json"),
+		SessionPath:      filepath.Join("data", "sessions.db"),
+		SessionLength:    24 * time.Hour,
+		RememberMeLength: 30 * 24 * time.Hour,
+		OutboxPath:       filepath.Join("data", "outbox.db"),
+		RollupPath:       filepath.Join("data", "rollup.db"),
+		RollupRefresh:    time.Minute,
+		SalesCacheTTL:    time.Minute,
+		Datastore: Datastore{
+			Timeout:         2 * time.Second,
+			Retries:         2,
+			RetryDelay:      200 * time.Millisecond,
+			BreakerFailures: 5,
+			BreakerCooldown: 30 * time.Second,
+			AuthCache:       30 * time.Second,
+			AuthGrace:       12 * time.Hour,
+		},
+		Login: Login{
+			IPLimit:          20,
+			IPWindow:         5 * time.Minute,
+			IdentityLimit:    10,
+			IdentityWindow:   15 * time.Minute,
+			LockoutFailures:  5,
+			Lockout:          15 * time.Minute,
+			AttemptRetention: 30 * 24 * time.Hour,
+		},
+		Headers: Headers{
+			CSP:            "enforce",
+			FrameOptions:   "DENY",
+			ReferrerPolicy: "same-origin",
+			HSTSMaxAge:     180 * 24 * time.Hour,
+		},
+		TLS: TLS{
+			Mode:     "off",
+			CertFile: filepath.Join("data", "tls", "cert.pem"),
+			KeyFile:  filepath.Join("data", "tls", "key.pem"),
+		},
+	}
+}
+
+// Loads and validates the config, every problem found is in the error
+func Load() (*Config, error) {
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Unable to read .env - %w", err)
+	}
+
+	c := defaults()
+
+	file := os.Getenv("CONFIG_FILE")
+	if file == "" {
+		if _, err := os.Stat("config.yaml"); err == nil {
+			file = "config.yaml"
+		}
+	}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read config file - %w", err)
+		}
+		if err := yaml.Unmarshal(b, c); err != nil {
+			return nil, fmt.Errorf("Unable to parse config file %s - %w", file, err)
+		}
+		c.File = file
+	}
+
+	var errs []error
+	env := envReader{errs: &errs}
+	env.int("PORT", &c.Port)
+	env.duration("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	env.string("LOG_LEVEL", &c.LogLevel)
+	env.string("LOG_FORMAT", &c.LogFormat)
+	env.string("METRICS_TOKEN", &c.MetricsToken)
+	env.string("STORE", &c.Store)
+	env.string("API_SERVER_ADDR", &c.APIServerAddr)
+	env.string("STORE_PATH", &c.StorePath)
+	env.string("EMBEDDED_ADMIN_IDENTITY", &c.EmbeddedAdminIdentity)
+	env.string("EMBEDDED_ADMIN_PASSWORD", &c.EmbeddedAdminPassword)
+	env.string("TARGETS_FILE", &c.TargetsFile)
+	env.string("ROLES_FILE", &c.RolesFile)
+	env.string("SESSION_PATH", &c.SessionPath)
+	env.duration("SESSION_LENGTH", &c.SessionLength)
+	env.duration("REMEMBER_ME_LENGTH", &c.RememberMeLength)
+	env.bool("COOKIE_SECURE", &c.CookieSecure)
+	env.string("OUTBOX_PATH", &c.OutboxPath)
+	env.string("ROLLUP_PATH", &c.RollupPath)
+	env.duration("ROLLUP_REFRESH", &c.RollupRefresh)
+	env.duration("SALES_CACHE_TTL", &c.SalesCacheTTL)
+	env.duration("DATASTORE_TIMEOUT", &c.Datastore.Timeout)
+	env.duration("DATASTORE_TIMEOUT_LOGIN", &c.Datastore.TimeoutLogin)
+	env.duration("DATASTORE_TIMEOUT_AUTH", &c.Datastore.TimeoutAuth)
+	env.duration("DATASTORE_TIMEOUT_FIND", &c.Datastore.TimeoutFind)
+	env.duration("DATASTORE_TIMEOUT_SALE", &c.Datastore.TimeoutSale)
+	env.int("DATASTORE_RETRIES", &c.Datastore.Retries)
+	env.duration("DATASTORE_RETRY_DELAY", &c.Datastore.RetryDelay)
+	env.int("DATASTORE_BREAKER_FAILURES", &c.Datastore.BreakerFailures)
+	env.duration("DATASTORE_BREAKER_COOLDOWN", &c.Datastore.BreakerCooldown)
+	env.duration("DATASTORE_AUTH_CACHE", &c.Datastore.AuthCache)
+	env.duration("DATASTORE_AUTH_GRACE", &c.Datastore.AuthGrace)
+	env.duration("DATASTORE_TOKEN_LENGTH", &c.Datastore.TokenLength)
+	env.int("LOGIN_IP_LIMIT", &c.Login.IPLimit)
+	env.duration("LOGIN_IP_WINDOW", &c.Login.IPWindow)
+	env.int("LOGIN_IDENTITY_LIMIT", &c.Login.IdentityLimit)
+	env.duration("LOGIN_IDENTITY_WINDOW", &c.Login.IdentityWindow)
+	env.int("LOGIN_LOCKOUT_FAILURES", &c.Login.LockoutFailures)
+	env.duration("LOGIN_LOCKOUT", &c.Login.Lockout)
+	env.duration("LOGIN_ATTEMPT_RETENTION", &c.Login.AttemptRetention)
+	env.string("CSP", &c.Headers.CSP)
+	env.string("CSP_REPORT_URI", &c.Headers.CSPReportURI)
+	env.string("FRAME_OPTIONS", &c.Headers.FrameOptions)
+	env.string("REFERRER_POLICY", &c.Headers.ReferrerPolicy)
+	env.duration("HSTS_MAX_AGE", &c.Headers.HSTSMaxAge)
+	env.string("TLS", &c.TLS.Mode)
+	env.string("TLS_CERT_FILE", &c.TLS.CertFile)
+	env.string("TLS_KEY_FILE", &c.TLS.KeyFile)
+	env.string("TLS_HOSTS", &c.TLS.Hosts)
+	env.int("TLS_REDIRECT_PORT", &c.TLS.RedirectPort)
+
+	// per-call timeouts fall back to the general one
+	for _, t := range []*time.Duration{&c.Datastore.TimeoutLogin, &c.Datastore.TimeoutAuth, &c.Datastore.TimeoutFind, &c.Datastore.TimeoutSale} {
+		if *t == 0 {
+			*t = c.Datastore.Timeout
+		}
+	}
+
+	// served over https, the cookies have no reason to go any other way
+	if c.TLS.Mode != "off" {
+		c.CookieSecure = true
+	}
+
+	errs = append(errs, c.validate()...)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c, nil
+}
+
+// Listen address for the http server
+func (c *Config) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// Listen address for the http to https redirect, empty when there is none
+func (c *Config) RedirectAddr() string {
+	if c.TLS.Mode == "off" || c.TLS.RedirectPort == 0 {
+		return ""
+	}
+	return ":" + strconv.Itoa(c.TLS.RedirectPort)
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Port < 1 || c.Port > 65535 {
+		fail("PORT must be between 1 and 65535, got %d", c.Port)
+	}
+
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		fail("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.LogFormat != "logfmt" && c.LogFormat != "json" {
+		fail("LOG_FORMAT must be logfmt or json, got %q", c.LogFormat)
+	}
+
+	switch c.Store {
+	case "http":
+		if c.APIServerAddr == "" {
+			fail("API_SERVER_ADDR is required when STORE is http")
+		} else if u, err := url.Parse(c.APIServerAddr); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("API_SERVER_ADDR must be an http(s) URL like http://127.0.0.1:3001, got %q", c.APIServerAddr)
+		}
+	case "embedded":
+		if c.StorePath == "" {
+			fail("STORE_PATH is required when STORE is embedded")
+		}
+	default:
+		fail("STORE must be http or embedded, got %q", c.Store)
+	}
+
+	for _, p := range []struct {
+		key  string
+		path string
+	}{{"TARGETS_FILE", c.TargetsFile}, {"ROLES_FILE", c.RolesFile}, {"SESSION_PATH", c.SessionPath}, {"OUTBOX_PATH", c.OutboxPath}, {"ROLLUP_PATH", c.RollupPath}} {
+		if p.path == "" {
+			fail("%s can't be empty", p.key)
+		}
+	}
+
+	positive := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"DATASTORE_TIMEOUT", c.Datastore.Timeout},
+		{"DATASTORE_TIMEOUT_LOGIN", c.Datastore.TimeoutLogin},
+		{"DATASTORE_TIMEOUT_AUTH", c.Datastore.TimeoutAuth},
+		{"DATASTORE_TIMEOUT_FIND", c.Datastore.TimeoutFind},
+		{"DATASTORE_TIMEOUT_SALE", c.Datastore.TimeoutSale},
+		{"DATASTORE_RETRY_DELAY", c.Datastore.RetryDelay},
+		{"DATASTORE_BREAKER_COOLDOWN", c.Datastore.BreakerCooldown},
+		{"DATASTORE_AUTH_CACHE", c.Datastore.AuthCache},
+		{"DATASTORE_AUTH_GRACE", c.Datastore.AuthGrace},
+		{"ROLLUP_REFRESH", c.RollupRefresh},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"SESSION_LENGTH", c.SessionLength},
+		{"REMEMBER_ME_LENGTH", c.RememberMeLength},
+		{"LOGIN_IP_WINDOW", c.Login.IPWindow},
+		{"LOGIN_IDENTITY_WINDOW", c.Login.IdentityWindow},
+		{"LOGIN_LOCKOUT", c.Login.Lockout},
+		{"LOGIN_ATTEMPT_RETENTION", c.Login.AttemptRetention},
+	}
+	for _, p := range positive {
+		if p.d <= 0 {
+			fail("%s must be more than 0, got %s", p.key, p.d)
+		}
+	}
+	for _, t := range positive[:5] {
+		if t.d > time.Minute {
+			fail("%s must be a minute or less, got %s", t.key, t.d)
+		}
+	}
+	if c.RememberMeLength < c.SessionLength {
+		fail("REMEMBER_ME_LENGTH can't be shorter than SESSION_LENGTH, got %s and %s", c.RememberMeLength, c.SessionLength)
+	}
+	if c.Datastore.TokenLength < 0 {
+		fail("DATASTORE_TOKEN_LENGTH can't be negative, got %s", c.Datastore.TokenLength)
+	}
+	if c.SalesCacheTTL < 0 {
+		fail("SALES_CACHE_TTL can't be negative, got %s", c.SalesCacheTTL)
+	}
+	if c.Datastore.Retries < 0 || c.Datastore.Retries > 10 {
+		fail("DATASTORE_RETRIES must be between 0 and 10, got %d", c.Datastore.Retries)
+	}
+	for _, n := range []struct {
+		key string
+		n   int
+	}{{"LOGIN_IP_LIMIT", c.Login.IPLimit}, {"LOGIN_IDENTITY_LIMIT", c.Login.IdentityLimit}, {"LOGIN_LOCKOUT_FAILURES", c.Login.LockoutFailures}} {
+		if n.n < 1 {
+			fail("%s must be at least 1, got %d", n.key, n.n)
+		}
+	}
+	switch c.Headers.CSP {
+	case "enforce", "report-only", "off":
+	default:
+		fail("CSP must be enforce, report-only or off, got %q", c.Headers.CSP)
+	}
+	if c.Headers.CSPReportURI != "" {
+		if u, err := url.Parse(c.Headers.CSPReportURI); err != nil || (u.Scheme == "" && u.Path == "") {
+			fail("CSP_REPORT_URI must be a URL or a path, got %q", c.Headers.CSPReportURI)
+		}
+	}
+	if c.Headers.FrameOptions != "DENY" && c.Headers.FrameOptions != "SAMEORIGIN" {
+		fail("FRAME_OPTIONS must be DENY or SAMEORIGIN, got %q", c.Headers.FrameOptions)
+	}
+	switch c.Headers.ReferrerPolicy {
+	case "no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin", "same-origin",
+		"strict-origin", "strict-origin-when-cross-origin", "unsafe-url":
+	default:
+		fail("REFERRER_POLICY must be a Referrer-Policy value like same-origin, got %q", c.Headers.ReferrerPolicy)
+	}
+	if c.Headers.HSTSMaxAge < 0 {
+		fail("HSTS_MAX_AGE can't be negative, got %s", c.Headers.HSTSMaxAge)
+	}
+	switch c.TLS.Mode {
+	case "off":
+	case "files", "self-signed":
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			fail("TLS_CERT_FILE and TLS_KEY_FILE are required when TLS is %s", c.TLS.Mode)
+		}
+		if c.TLS.RedirectPort < 0 || c.TLS.RedirectPort > 65535 {
+			fail("TLS_REDIRECT_PORT must be between 0 and 65535, got %d", c.TLS.RedirectPort)
+		} else if c.TLS.RedirectPort == c.Port {
+			fail("TLS_REDIRECT_PORT can't be the same as PORT, got %d", c.TLS.RedirectPort)
+		}
+	default:
+		fail("TLS must be off, files or self-signed, got %q", c.TLS.Mode)
+	}
+	if c.Datastore.BreakerFailures < 1 {
+		fail("DATASTORE_BREAKER_FAILURES must be at least 1, got %d", c.Datastore.BreakerFailures)
+	}
+
+	return errs
+}
+
+// Overrides config values with the environment variables that are set, collecting parse errors
+type envReader struct {
+	errs *[]error
+}
+
+func (e envReader) string(key string, v *string) {
+	if s, ok := os.LookupEnv(key); ok {
+		*v = s
+	}
+}
+
+func (e envReader) int(key string, v *int) {
+	s, ok := os.LookupEnv(key)
+	if !ok || s == "" {
+		return
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		*e.errs = append(*e.errs, fmt.Errorf("%s must be a whole number, got %q", key, s))
+		return
+	}
+	*v = n
+}
+
+func (e envReader) duration(key string, v *time.Duration) {
+	s, ok := os.LookupEnv(key)
+	if !ok || s == "" {
+		return
+	}
+	// plain 0 is fine, mostly for SALES_CACHE_TTL=0
+	if s == "0" {
+		*v = 0
+		return
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		*e.errs = append(*e.errs, fmt.Errorf("%s must be a duration like 2s or 500ms, got %q", key, s))
+		return
+	}
+	*v = d
+}
+
+func (e envReader) bool(key string, v *bool) {
+	s, ok := os.LookupEnv(key)
+	if !ok || s == "" {
+		return
+	}
+	b, err := strconv.ParseBool(s)
 	if err != nil {
-		fmt.Print("Error loading .env file")
+		*e.errs = append(*e.errs, fmt.Errorf("%s must be true or false, got %q", key, s))
+		return
 	}
-	return os.Getenv(key)
+	*v = b
 }
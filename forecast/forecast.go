@@ -0,0 +1,182 @@
+// Package forecast predicts how many units of each product an operation will sell on a given day,
+// so we can buy just enough pineapples for it.
+//
+// The model is deliberately simple: Holt's double exponential smoothing over the days the operation ran
+// (recent level + trend), scaled by a weekday factor and a month-of-year factor. Factors are shrunk
+// towards 1 when there are only a few days to learn them from.
+package forecast
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+const (
+	// smoothing for the level and trend, higher reacts faster to recent days
+	Alpha = 0.3
+	Beta  = 0.1
+
+	// how many "average days" a weekday/month factor is shrunk towards 1 with
+	shrinkage = 3.0
+
+	// extra fruit on top of the forecast, kept small since over-buying is what rots
+	SafetyMargin = 0.05
+)
+
+type day struct {
+	date  time.Time
+	units float64
+}
+
+// Forecasts units per product for the operation on date, using every day the operation ran before it.
+func Predict(history []*model.DailyRollup, operationId int, date time.Time) *model.ViewForecast {
+	date = helper.BucketStart(date, helper.BucketDay)
+	operation, err := helper.ParseOperationToString(operationId)
+	if err != nil {
+		operation = "Unknown"
+	}
+
+	f := &model.ViewForecast{
+		OperationID: operationId,
+		Operation:   operation,
+		Date:        date.Format("2006-01-02"),
+		Weekday:     date.Weekday().String(),
+		Products:    []*model.ViewProductForecast{},
+	}
+
+	// units per product per day the operation ran, only from before the forecast date
+	days := map[time.Time]bool{}
+	units := map[int]map[time.Time]float64{}
+	for _, rollup := range history {
+		op, ok := rollup.Operations[operationId]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseInLocation("2006-01-02", rollup.Date, date.Location())
+		if err != nil || !d.Before(date) {
+			continue
+		}
+		days[d] = true
+		for itemId, a := range op.Items {
+			if units[itemId] == nil {
+				units[itemId] = map[time.Time]float64{}
+			}
+			units[itemId][d] += a.Qty
+		}
+	}
+
+	// every product gets a value for every operation day, days it didnt sell count as 0
+	operationDays := make([]time.Time, 0, len(days))
+	for d := range days {
+		operationDays = append(operationDays, d)
+	}
+	sort.Slice(operationDays, func(i, j int) bool { return operationDays[i].Before(operationDays[j]) })
+
+	itemIds := make([]int, 0, len(units))
+	for id := range units {
+		itemIds = append(itemIds, id)
+	}
+	sort.Ints(itemIds)
+
+	var fruit float64
+	for _, id := range itemIds {
+		series := make([]day, 0, len(operationDays))
+		for _, d := range operationDays {
+			series = append(series, day{date: d, units: units[id][d]})
+		}
+
+		p := predictProduct(series, date)
+		p.ItemID = id
+		p.Product, err = helper.ParseItemToString(id)
+		if err != nil {
+			p.Product = "Unknown"
+		}
+		f.Products = append(f.Products, p)
+
+		usage, _ := helper.ParseItemToFruitUsage(id)
+		fruit += p.Units * usage
+	}
+
+	f.DaysOfHistory = len(operationDays)
+	f.FruitToBuy = int(math.Ceil(fruit * (1 + SafetyMargin)))
+
+	return f
+}
+
+func predictProduct(series []day, date time.Time) *model.ViewProductForecast {
+	p := &model.ViewProductForecast{WeekdayFactor: 1, SeasonFactor: 1}
+	if len(series) == 0 {
+		return p
+	}
+
+	var total float64
+	for _, d := range series {
+		total += d.units
+	}
+	mean := total / float64(len(series))
+
+	weekdayFactors := map[time.Weekday]float64{}
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		weekday := wd
+		weekdayFactors[wd] = factor(series, mean, func(d day) bool {
+			return d.date.Weekday() == weekday
+		})
+	}
+
+	// take the weekday pattern out before smoothing, otherwise a busy saturday looks like a trend
+	adjusted := make([]day, 0, len(series))
+	for _, d := range series {
+		adjusted = append(adjusted, day{date: d.date, units: d.units / weekdayFactors[d.date.Weekday()]})
+	}
+	level, trend := holt(adjusted)
+	if math.Abs(trend) < 0.005 {
+		trend = 0
+	}
+
+	p.WeekdayFactor = helper.RoundTo(weekdayFactors[date.Weekday()], 2)
+	// seasonality only from previous years, this year's recent days are already in the level
+	p.SeasonFactor = helper.RoundTo(factor(series, mean, func(d day) bool {
+		return d.date.Month() == date.Month() && d.date.Year() < date.Year()
+	}), 2)
+
+	p.Level = helper.RoundTo(level, 2)
+	p.Trend = helper.RoundTo(trend, 2)
+	p.Units = helper.RoundTo(math.Max(0, level+trend)*p.WeekdayFactor*p.SeasonFactor, 2)
+
+	return p
+}
+
+// Holt's linear exponential smoothing, returns the level and trend after the last day
+func holt(series []day) (float64, float64) {
+	level := series[0].units
+	trend := 0.0
+	for _, d := range series[1:] {
+		prevLevel := level
+		level = Alpha*d.units + (1-Alpha)*(level+trend)
+		trend = Beta*(level-prevLevel) + (1-Beta)*trend
+	}
+	return level, trend
+}
+
+// Average of the matching days relative to the overall average, shrunk towards 1 when there are few of them
+func factor(series []day, mean float64, match func(day) bool) float64 {
+	if mean == 0 {
+		return 1
+	}
+	var total, n float64
+	for _, d := range series {
+		if match(d) {
+			total += d.units
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	raw := (total / n) / mean
+	return (n*raw + shrinkage) / (n + shrinkage)
+}
@@ -0,0 +1,146 @@
+package forecast
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// 2024-01-01 is a monday
+var firstDay = time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+// A rollup per day from firstDay of raw fruit (1 fruit per unit) sold by operation 1, units gives each day's qty
+func history(n int, units func(i int, d time.Time) float64) []*model.DailyRollup {
+	days := []*model.DailyRollup{}
+	for i := 0; i < n; i++ {
+		d := firstDay.AddDate(0, 0, i)
+		days = append(days, &model.DailyRollup{
+			Date: d.Format("2006-01-02"),
+			Operations: map[int]*model.OperationRollup{
+				1: {Items: map[int]*model.SalesAggregate{3: {Qty: units(i, d)}}},
+			},
+		})
+	}
+	return days
+}
+
+func TestPredict(t *testing.T) {
+	flat := func(i int, d time.Time) float64 { return 10 }
+	rising := func(i int, d time.Time) float64 { return 10 + float64(i) }
+	busySaturday := func(i int, d time.Time) float64 {
+		if d.Weekday() == time.Saturday {
+			return 30
+		}
+		return 10
+	}
+	monday := firstDay.AddDate(0, 0, 56)
+	saturday := firstDay.AddDate(0, 0, 54)
+
+	tests := []struct {
+		name    string
+		history []*model.DailyRollup
+		date    time.Time
+
+		wantDays int
+		// ranges for the one product, not checked without history
+		minUnits, maxUnits     float64
+		minWeekday, maxWeekday float64
+		minTrend, maxTrend     float64
+	}{
+		{
+			name:     "no history",
+			history:  []*model.DailyRollup{},
+			date:     firstDay,
+			wantDays: 0,
+		},
+		{
+			name:     "one day",
+			history:  history(1, flat),
+			date:     firstDay.AddDate(0, 0, 1),
+			wantDays: 1,
+			minUnits: 10, maxUnits: 10,
+			minWeekday: 1, maxWeekday: 1,
+		},
+		{
+			// days on or after the forecast date are left out
+			name:     "short history before the date",
+			history:  history(28, flat),
+			date:     firstDay.AddDate(0, 0, 3),
+			wantDays: 3,
+			minUnits: 10, maxUnits: 10,
+			minWeekday: 1, maxWeekday: 1,
+		},
+		{
+			name:     "flat",
+			history:  history(56, flat),
+			date:     monday,
+			wantDays: 56,
+			minUnits: 10, maxUnits: 10,
+			minWeekday: 1, maxWeekday: 1,
+		},
+		{
+			name:     "rising",
+			history:  history(56, rising),
+			date:     monday,
+			wantDays: 56,
+			minUnits: 60, maxUnits: 70,
+			minWeekday: 0.9, maxWeekday: 1.1,
+			minTrend: 0.5, maxTrend: 1.5,
+		},
+		{
+			name:     "busy saturday on a monday",
+			history:  history(56, busySaturday),
+			date:     monday,
+			wantDays: 56,
+			minUnits: 8, maxUnits: 11,
+			minWeekday: 0.7, maxWeekday: 0.9,
+			minTrend: -0.1, maxTrend: 0.1,
+		},
+		{
+			name:     "busy saturday on a saturday",
+			history:  history(54, busySaturday),
+			date:     saturday,
+			wantDays: 54,
+			minUnits: 20, maxUnits: 32,
+			minWeekday: 1.8, maxWeekday: 2.5,
+			minTrend: -0.1, maxTrend: 0.1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := Predict(tt.history, 1, tt.date)
+			if f.DaysOfHistory != tt.wantDays {
+				t.Errorf("days of history = %d, want %d", f.DaysOfHistory, tt.wantDays)
+			}
+			if tt.wantDays == 0 {
+				if len(f.Products) != 0 || f.FruitToBuy != 0 {
+					t.Errorf("forecast without history = %d products, %d fruit, want none", len(f.Products), f.FruitToBuy)
+				}
+				return
+			}
+			if len(f.Products) != 1 {
+				t.Fatalf("products = %d, want 1", len(f.Products))
+			}
+
+			p := f.Products[0]
+			if p.Units < tt.minUnits || p.Units > tt.maxUnits {
+				t.Errorf("units = %v, want %v to %v", p.Units, tt.minUnits, tt.maxUnits)
+			}
+			if p.WeekdayFactor < tt.minWeekday || p.WeekdayFactor > tt.maxWeekday {
+				t.Errorf("weekday factor = %v, want %v to %v", p.WeekdayFactor, tt.minWeekday, tt.maxWeekday)
+			}
+			if p.Trend < tt.minTrend || p.Trend > tt.maxTrend {
+				t.Errorf("trend = %v, want %v to %v", p.Trend, tt.minTrend, tt.maxTrend)
+			}
+			if p.SeasonFactor != 1 {
+				t.Errorf("season factor = %v, want 1 without previous years", p.SeasonFactor)
+			}
+			if want := int(math.Ceil(p.Units * (1 + SafetyMargin))); f.FruitToBuy != want {
+				t.Errorf("fruit to buy = %d, want %d", f.FruitToBuy, want)
+			}
+		})
+	}
+}
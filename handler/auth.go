@@ -1,16 +1,13 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"time"
+	"errors"
+	"strings"
 
-	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
 	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/CRTOsp3ck/mims-app/store"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -20,62 +17,52 @@ func LoginRequest(c *fiber.Ctx) error {
 	if err := c.BodyParser(auth); err != nil {
 		return err
 	}
+	auth.Identity = strings.TrimSpace(auth.Identity)
+	if auth.Identity == "" || auth.Password == "" {
+		return loginFailed(c, loginMissing)
+	}
 
-	url := config.Config("API_SERVER_ADDR") + "/auth/login"
-
-	bytesObj := []byte(fmt.Sprintf(`{
-			"identity": %q,
-			"password": %q
-		}`, auth.Identity, auth.Password))
-	body := bytes.NewBuffer(bytesObj)
+	if blocked, err := loginBlocked(c, auth.Identity); blocked {
+		return err
+	}
 
-	req, err := http.NewRequest(http.MethodPost, url, body)
+	token, err := store.Current().Login(c.UserContext(), auth.Identity, auth.Password)
 	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/login")
+		result := model.LoginError
+		var unavailable *store.UnavailableError
+		switch {
+		case errors.Is(err, store.ErrInvalidCredentials):
+			result = model.LoginInvalidCredentials
+		case errors.Is(err, store.ErrUserDisabled):
+			result = model.LoginDisabled
+		case errors.As(err, &unavailable):
+			result = model.LoginUnavailable
+		}
+		recordLoginAttempt(c, auth.Identity, result, err)
+		return loginFailed(c, result)
 	}
 
-	req.Header.Set("User-Agent", "mims-app")
-	req.Header.Add("Content-Type", "application/json")
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-	res, err := client.Do(req)
+	user, err := store.Current().User(auth.Identity)
 	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/login")
+		recordLoginAttempt(c, auth.Identity, model.LoginError, err)
+		return loginFailed(c, model.LoginError)
 	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
+	if user.Disabled {
+		recordLoginAttempt(c, auth.Identity, model.LoginDisabled, nil)
+		return loginFailed(c, model.LoginDisabled)
 	}
-
-	b, err := io.ReadAll(res.Body)
+	sess, err := startSession(c, token, user, auth.RememberMe)
 	if err != nil {
-		log.Println("Error reading response body -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/login")
+		recordLoginAttempt(c, auth.Identity, model.LoginError, err)
+		return loginFailed(c, model.LoginError)
 	}
+	recordLoginAttempt(c, auth.Identity, model.LoginSuccess, nil)
+	logger.FromContext(c.UserContext()).Info("Logged in", "identity", auth.Identity, "role", sess.Role, "remember_me", auth.RememberMe)
 
-	var respBody model.ResponseBody
-	err = json.Unmarshal(b, &respBody)
-	if err != nil {
-		log.Println("Error unmarshalling response body -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/login")
+	// new accounts and reset passwords get a new password before anything else
+	if user.MustChangePassword {
+		return c.Redirect("/main/account/password")
 	}
-
-	// Create cookie
-	cookie := new(fiber.Cookie)
-	cookie.Name = "token"
-	cookie.Value = respBody.Data
-	cookie.Expires = time.Now().Add(24 * time.Hour)
-
-	// Set cookie
-	c.Cookie(cookie)
-
 	return c.Redirect("/main")
 }
 
@@ -83,22 +70,22 @@ func LoginRequest(c *fiber.Ctx) error {
 func LoginPage(c *fiber.Ctx) error {
 	return c.Render("login", fiber.Map{
 		"Title": "Login",
+		"Error": loginMessage(c.Query("error"), c.Query("wait")),
 	})
 }
 
 // Auth - Logout
 func LogoutRequest(c *fiber.Ctx) error {
-	// Clear cookie
-	c.ClearCookie("token")
+	if err := session.Current().Delete(c.Cookies(TokenCookie)); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error ending session", "err", err)
+	}
+	if refresh := c.Cookies(RememberCookie); refresh != "" {
+		if err := session.Current().Forget(refresh); err != nil {
+			logger.FromContext(c.UserContext()).Error("Error forgetting remembered login", "err", err)
+		}
+	}
 
-	// Set cookie expiration to past
-	c.Cookie(&fiber.Cookie{
-		Name: "token",
-		// Set expiry date to the past
-		Expires:  time.Now().Add(-(time.Hour * 2)),
-		HTTPOnly: true,
-		SameSite: "lax",
-	})
+	clearAuthCookies(c)
 
 	return c.Redirect("/main/login")
 }
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Chart data for the sales report - GET /main/sales-report/chart/:chart?sd=YYYY-MM-DD&ed=YYYY-MM-DD&bucket=day|week|month
+func SalesReportChart(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return jsonError(c, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired JWT"))
+	}
+
+	q := new(model.ChartQuery)
+	if err := c.QueryParser(q); err != nil {
+		return jsonError(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+	}
+
+	bucket, err := helper.ParseBucket(q.Bucket)
+	if err != nil {
+		return jsonError(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+	}
+
+	days, start, end, err := fetchChartRollups(c, q)
+	if err != nil {
+		return jsonError(c, err)
+	}
+	// one entry per bucket goes back, a range of centuries shouldn't be drawn day by day
+	if !helper.ChartRangeFits(start, end, bucket) {
+		return jsonError(c, fiber.NewError(fiber.StatusBadRequest,
+			"Range too long for "+bucket+" buckets, at most "+strconv.Itoa(helper.MaxChartBuckets[bucket])+", pick a shorter range or bigger buckets"))
+	}
+
+	var chart *model.ChartData
+	switch c.Params("chart") {
+	case "income-expenses":
+		chart = helper.ChartIncomeExpenses(days, start, end, bucket)
+	case "revenue-cash-flow":
+		chart = helper.ChartRevenueCashFlow(days, start, end, bucket)
+	case "product-trend":
+		chart = helper.ChartProductTrend(days, start, end, bucket)
+	case "product-movement":
+		chart = helper.ChartProductMovement(days)
+	case "fruit-consumption":
+		chart = helper.ChartFruitConsumption(days, start, end, bucket)
+	default:
+		return jsonError(c, fiber.NewError(fiber.StatusNotFound, "Unknown chart"))
+	}
+
+	return c.JSON(chart)
+}
+
+// Hour of operation x weekday heatmap per product - GET /main/sales-report/heatmap?sd=YYYY-MM-DD&ed=YYYY-MM-DD
+func SalesReportHeatmap(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return jsonError(c, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired JWT"))
+	}
+
+	q := new(model.ChartQuery)
+	if err := c.QueryParser(q); err != nil {
+		return jsonError(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+	}
+
+	days, _, _, err := fetchChartRollups(c, q)
+	if err != nil {
+		return jsonError(c, err)
+	}
+
+	return c.JSON(helper.CalculateHourlyHeatmaps(days))
+}
+
+// Fetches the daily rollups for the range in the query (lifetime when there is none) and works out the chart start and end
+func fetchChartRollups(c *fiber.Ctx, q *model.ChartQuery) ([]*model.DailyRollup, time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+	if q.StartDate != "" || q.EndDate != "" {
+		start, err = time.ParseInLocation("2006-01-02", q.StartDate, time.Local)
+		if err != nil {
+			return nil, start, end, fiber.NewError(fiber.StatusBadRequest, "Unable to parse start date")
+		}
+		end, err = time.ParseInLocation("2006-01-02", q.EndDate, time.Local)
+		if err != nil || end.Before(start) {
+			return nil, start, end, fiber.NewError(fiber.StatusBadRequest, "Unable to parse end date")
+		}
+	}
+
+	days, err := rollup.Fetch(c, q.StartDate, q.EndDate)
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups for chart", "err", err)
+		return nil, start, end, fiber.NewError(fiber.StatusBadGateway, "Unable to fetch sales")
+	}
+
+	if q.StartDate == "" {
+		start, end = helper.RollupDateRange(days)
+	}
+
+	return days, start, end, nil
+}
+
+// Sends err as a ResponseBody, using the status code when its a *fiber.Error
+func jsonError(c *fiber.Ctx, err error) error {
+	code := fiber.StatusInternalServerError
+	var e *fiber.Error
+	if errors.As(err, &e) {
+		code = e.Code
+	}
+	return c.Status(code).JSON(model.ResponseBody{
+		Message: err.Error(),
+		Status:  "error",
+	})
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// the store's token, a browser-session cookie
+	TokenCookie = "token"
+	// the one-time refresh token of a "remember me" login
+	RememberCookie = "remember"
+	// a form's outcome, shown once on the page it redirects back to
+	flashCookie = "flash"
+)
+
+var cookieSecure bool
+
+// Cookie settings from the config, call once at startup
+func InitCookies(cfg *config.Config) {
+	cookieSecure = cfg.CookieSecure
+}
+
+// Neither cookie is readable from js, and they aren't sent along with requests from other sites.
+// Lax rather than strict so following a link to the app from elsewhere keeps you logged in.
+func authCookie(name string, value string) *fiber.Cookie {
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HTTPOnly: true,
+		Secure:   cookieSecure,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	}
+}
+
+// The token cookie goes when the browser closes, the app's session ends before that if it's left open
+func setTokenCookie(c *fiber.Ctx, token string) {
+	c.Cookie(authCookie(TokenCookie, token))
+	// so the rest of this request sees it too
+	c.Request().Header.SetCookie(TokenCookie, token)
+}
+
+func setRememberCookie(c *fiber.Ctx, refresh string) {
+	cookie := authCookie(RememberCookie, refresh)
+	cookie.Expires = time.Now().Add(session.Current().RememberLength())
+	c.Cookie(cookie)
+}
+
+func clearAuthCookies(c *fiber.Ctx) {
+	for _, name := range []string{TokenCookie, RememberCookie} {
+		cookie := authCookie(name, "")
+		// Set expiry date to the past
+		cookie.Expires = time.Now().Add(-(time.Hour * 2))
+		c.Cookie(cookie)
+	}
+}
+
+// Keeps message for the page the redirect goes to, kind is "error" or "success".
+// Not in the url, so a link can't put its own text on one of our pages.
+func setFlash(c *fiber.Ctx, kind string, message string) {
+	cookie := authCookie(flashCookie, kind+":"+base64.RawURLEncoding.EncodeToString([]byte(message)))
+	cookie.SessionOnly = true
+	c.Cookie(cookie)
+}
+
+// The message setFlash left for this page, and clears it so a reload doesn't show it again
+func takeFlash(c *fiber.Ctx) (errorMessage string, successMessage string) {
+	value := c.Cookies(flashCookie)
+	if value == "" {
+		return "", ""
+	}
+	cookie := authCookie(flashCookie, "")
+	cookie.Expires = time.Now().Add(-(time.Hour * 2))
+	c.Cookie(cookie)
+
+	kind, encoded, _ := strings.Cut(value, ":")
+	message, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ""
+	}
+	switch kind {
+	case "error":
+		return string(message), ""
+	case "success":
+		return "", string(message)
+	}
+	return "", ""
+}
+
+// Logs a "remember me" browser back in when its session is over (browser closed, session expired),
+// as long as the store still takes the token. The refresh token is swapped for a new one each time,
+// and so is the store's token when the store can renew it (see DATASTORE_TOKEN_LENGTH when it can't).
+func RestoreSession(c *fiber.Ctx) error {
+	refresh := c.Cookies(RememberCookie)
+	if refresh == "" || helper.CurrentSession(c) != nil {
+		return c.Next()
+	}
+
+	log := logger.FromContext(c.UserContext())
+	remembered, err := session.Current().TakeRemembered(refresh)
+	if err != nil {
+		log.Error("Error reading remembered login", "err", err)
+		return c.Next()
+	}
+	if remembered == nil {
+		log.Debug("Remembered login expired or unknown")
+		clearAuthCookies(c)
+		return c.Next()
+	}
+
+	ok, err := store.Current().CheckAuth(c.UserContext(), remembered.Token)
+	if err != nil || !ok {
+		log.Info("Remembered login no longer accepted", "identity", remembered.Identity, "err", err)
+		clearAuthCookies(c)
+		return c.Next()
+	}
+	user, err := store.Current().User(remembered.Identity)
+	if err != nil || user.Disabled {
+		log.Info("Remembered login for unavailable account", "identity", remembered.Identity, "err", err)
+		clearAuthCookies(c)
+		return c.Next()
+	}
+
+	token := remembered.Token
+	renewed, err := store.Current().RenewToken(c.UserContext(), token)
+	switch {
+	case err == nil:
+		token = renewed
+	case errors.Is(err, store.ErrRenewUnsupported):
+		// good until the datastore's token expires, CheckAuth turns it away after that
+		log.Debug("Store can't renew tokens, keeping the remembered one")
+	default:
+		log.Warn("Error renewing remembered token, keeping the old one", "identity", user.Identity, "err", err)
+	}
+
+	if _, err := startSession(c, token, user, true); err != nil {
+		log.Error("Error restoring session", "err", err)
+		return c.Next()
+	}
+	log.Info("Restored remembered login", "identity", user.Identity)
+	return c.Next()
+}
+
+// Creates the session for token and sets the cookies, remember adds a new "remember me" refresh token
+func startSession(c *fiber.Ctx, token string, user *model.User, remember bool) (*model.Session, error) {
+	role := user.Role
+	if !helper.ValidRole(role) {
+		logger.FromContext(c.UserContext()).Warn("Unknown role, using viewer", "identity", user.Identity, "role", role)
+		role = model.RoleViewer
+	}
+
+	sess, err := session.Current().Create(token, user.Identity, role, user.MustChangePassword)
+	if err != nil {
+		return nil, err
+	}
+	setTokenCookie(c, token)
+	// their sales queued under a token that has since expired can go with this one
+	outbox.Current().Adopt(user.Identity, token)
+
+	if remember {
+		refresh, err := session.Current().Remember(token, user.Identity)
+		if err != nil {
+			// logged in all the same, just not remembered
+			logger.FromContext(c.UserContext()).Error("Error remembering login", "err", err)
+		} else {
+			setRememberCookie(c, refresh)
+		}
+	}
+	return sess, nil
+}
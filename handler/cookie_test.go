@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestFlash(t *testing.T) {
+	app := fiber.New()
+	app.Get("/set/:kind", func(c *fiber.Ctx) error {
+		setFlash(c, c.Params("kind"), "Created ann, they pick their own password")
+		return nil
+	})
+	app.Get("/take", func(c *fiber.Ctx) error {
+		errorMessage, successMessage := takeFlash(c)
+		return c.SendString(errorMessage + "|" + successMessage)
+	})
+	// the cookie value setFlash leaves
+	set := func(kind string) string {
+		resp, err := app.Test(httptest.NewRequest("GET", "/set/"+kind, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Cookies()[0].Value
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"none", "", "|"},
+		{"error", set("error"), "Created ann, they pick their own password|"},
+		{"success", set("success"), "|Created ann, they pick their own password"},
+		{"not base64", "error:<b>hi</b>", "|"},
+		{"unknown kind", "warning:aGk", "|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/take", nil)
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", flashCookie+"="+tt.cookie)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if got := string(body); got != tt.want {
+				t.Errorf("takeFlash = %q, want %q", got, tt.want)
+			}
+			// shown once
+			if tt.cookie != "" && (len(resp.Cookies()) != 1 || resp.Cookies()[0].Value != "") {
+				t.Errorf("flash cookie wasn't cleared: %v", resp.Cookies())
+			}
+		})
+	}
+}
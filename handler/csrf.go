@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// Locals key the token is kept under for LayoutData, and the hidden form field it comes back in
+	CSRFLocal = "csrf"
+	CSRFField = "_csrf"
+	// for requests sent from js instead of a form
+	CSRFHeader = "X-CSRF-Token"
+	csrfCookie = "csrf_"
+)
+
+var (
+	errCSRFMissing  = errors.New("csrf token missing")
+	errCSRFMismatch = errors.New("csrf token doesn't match the cookie")
+)
+
+// Paths that never render a form or take one, so they don't need a token cookie
+var csrfSkipPrefixes = []string{"/static/", "/health/", "/healthz", "/readyz", "/metrics"}
+
+// Gives each browser a token (in the csrf_ cookie and .CSRFToken for the templates) and turns away any
+// POST whose _csrf field or X-CSRF-Token header isn't that same token. Another site can make the browser
+// send the cookie but can't read it to fill in the field.
+// Nothing is kept on our side, so tokens survive restarts and stay the same across tabs until the browser closes
+// (fiber's csrf middleware keeps them in memory and hands out a new one after every POST).
+func CSRF(c *fiber.Ctx) error {
+	for _, prefix := range csrfSkipPrefixes {
+		if strings.HasPrefix(c.Path(), prefix) {
+			return c.Next()
+		}
+	}
+
+	token := c.Cookies(csrfCookie)
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+		if !validCSRFToken(token) {
+			var err error
+			if token, err = newCSRFToken(); err != nil {
+				return err
+			}
+			cookie := authCookie(csrfCookie, token)
+			cookie.SessionOnly = true
+			c.Cookie(cookie)
+		}
+	default:
+		if err := checkCSRF(c, token); err != nil {
+			return csrfFailed(c, err)
+		}
+	}
+
+	c.Locals(CSRFLocal, token)
+	// the page has the token in it, it can't be cached for someone else
+	c.Vary(fiber.HeaderCookie)
+	return c.Next()
+}
+
+// The token sent with the request has to be the one in this browser's cookie
+func checkCSRF(c *fiber.Ctx, cookieToken string) error {
+	token := c.FormValue(CSRFField)
+	if token == "" {
+		token = c.Get(CSRFHeader)
+	}
+	if token == "" || cookieToken == "" {
+		return errCSRFMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cookieToken)) != 1 {
+		return errCSRFMismatch
+	}
+	return nil
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validCSRFToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// Most of these are a form loaded before the browser was restarted or the cookie was cleared, so the page says to reload
+func csrfFailed(c *fiber.Ctx, err error) error {
+	logger.FromContext(c.UserContext()).Warn("CSRF check failed", "path", c.Path(), "ip", c.IP(), "err", err)
+
+	c.Status(fiber.StatusForbidden)
+	if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+		return c.JSON(fiber.Map{"error": "Form expired, reload the page and try again"})
+	}
+	back := c.Get(fiber.HeaderReferer)
+	// only ever back into the app
+	if !strings.HasPrefix(back, c.BaseURL()+"/") {
+		back = "/main"
+	}
+	return c.Render("csrf", fiber.Map{
+		"Title": "Form Expired",
+		"Back":  back,
+	})
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+func csrfApp() *fiber.App {
+	app := fiber.New(fiber.Config{Views: html.New("../views", ".html")})
+	app.Use(CSRF)
+	app.Get("/main/new-sale", func(c *fiber.Ctx) error {
+		token, _ := c.Locals(CSRFLocal).(string)
+		return c.SendString(token)
+	})
+	app.Post("/main/new-sale", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Post("/metrics", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	return app
+}
+
+func csrfCookieOf(resp *http.Response) string {
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == csrfCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestCSRFToken(t *testing.T) {
+	app := csrfApp()
+	valid := strings.Repeat("ab", 32)
+
+	tests := []struct {
+		name      string
+		cookie    string
+		wantNew   bool
+		wantLocal string // empty for whatever new token was set
+	}{
+		{"first visit", "", true, ""},
+		{"token kept", valid, false, valid},
+		{"too short", "abcd", true, ""},
+		{"not hex", strings.Repeat("zz", 32), true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/main/new-sale", nil)
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", csrfCookie+"="+tt.cookie)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			set := csrfCookieOf(resp)
+
+			if (set != "") != tt.wantNew {
+				t.Fatalf("new cookie %q, want one %v", set, tt.wantNew)
+			}
+			want := tt.wantLocal
+			if tt.wantNew {
+				if !validCSRFToken(set) {
+					t.Errorf("new token %q isn't 32 bytes of hex", set)
+				}
+				want = set
+			}
+			if string(body) != want {
+				t.Errorf("template token = %q, want %q", body, want)
+			}
+		})
+	}
+}
+
+func TestCSRFCheck(t *testing.T) {
+	app := csrfApp()
+	token := strings.Repeat("ab", 32)
+	other := strings.Repeat("cd", 32)
+
+	tests := []struct {
+		name   string
+		path   string
+		cookie string
+		field  string
+		header string
+		json   bool
+		want   int
+	}{
+		{"field matches", "/main/new-sale", token, token, "", false, fiber.StatusOK},
+		{"header matches", "/main/new-sale", token, "", token, true, fiber.StatusOK},
+		{"no token", "/main/new-sale", token, "", "", true, fiber.StatusForbidden},
+		{"no cookie", "/main/new-sale", "", token, "", true, fiber.StatusForbidden},
+		{"neither", "/main/new-sale", "", "", "", true, fiber.StatusForbidden},
+		{"field from another browser", "/main/new-sale", token, other, "", true, fiber.StatusForbidden},
+		{"header from another browser", "/main/new-sale", token, "", other, true, fiber.StatusForbidden},
+		{"a prefix of the token", "/main/new-sale", token, token[:32], "", true, fiber.StatusForbidden},
+		// the form expired page
+		{"html", "/main/new-sale", token, other, "", false, fiber.StatusForbidden},
+		{"skipped path", "/metrics", "", "", "", true, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.field != "" {
+				form.Set(CSRFField, tt.field)
+			}
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+			if tt.cookie != "" {
+				req.Header.Set("Cookie", csrfCookie+"="+tt.cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.json {
+				req.Header.Set("Accept", fiber.MIMEApplicationJSON)
+			}
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				body, _ := io.ReadAll(resp.Body)
+				t.Errorf("status = %d, want %d (%s)", resp.StatusCode, tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/forecast"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Demand forecast page - GET /main/forecast?operation=1&date=YYYY-MM-DD
+func Forecast(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	f, err := buildForecast(c)
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error building forecast", "err", err)
+	}
+
+	//pass it to the renderer
+	return c.Render("forecast", fiber.Map{
+		"Title":    "Forecast",
+		"Forecast": f,
+		"Error":    err,
+	}, "layouts/main")
+}
+
+// Demand forecast as JSON - GET /main/forecast/json?operation=1&date=YYYY-MM-DD
+func ForecastJSON(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return jsonError(c, fiber.NewError(fiber.StatusUnauthorized, "Invalid or expired JWT"))
+	}
+
+	f, err := buildForecast(c)
+	if err != nil {
+		return jsonError(c, err)
+	}
+
+	return c.JSON(f)
+}
+
+// Defaults to operation 1 and tomorrow
+func buildForecast(c *fiber.Ctx) (*model.ViewForecast, error) {
+	q := new(model.ForecastQuery)
+	if err := c.QueryParser(q); err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if q.OperationID == 0 {
+		q.OperationID = 1
+	}
+
+	date := time.Now().AddDate(0, 0, 1)
+	if q.Date != "" {
+		var err error
+		date, err = time.ParseInLocation("2006-01-02", q.Date, time.Local)
+		if err != nil {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unable to parse forecast date")
+		}
+	}
+
+	history, err := rollup.Fetch(c, "", "")
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups for forecast", "err", err)
+		return nil, fiber.NewError(fiber.StatusBadGateway, "Unable to fetch sales")
+	}
+
+	return forecast.Predict(history, q.OperationID, date), nil
+}
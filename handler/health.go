@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"sync/atomic"
+
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/template/html/v2"
+)
+
+// set once the app starts shutting down, so readiness fails while requests drain
+var shuttingDown atomic.Bool
+
+// Marks the app as going away, /readyz answers 503 from here on
+func ShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Datastore state and circuit breaker (JSON), 503 while the datastore is marked down
+func DatastoreHealth(c *fiber.Ctx) error {
+	h := store.Current().Health()
+	if !h.Available {
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(h)
+}
+
+// Liveness, the process is up and serving
+func Healthz(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// Readiness (JSON), 503 unless the store answers, the templates are loaded and the app isn't shutting down
+func Readyz(c *fiber.Ctx) error {
+	checks := fiber.Map{}
+	ready := true
+
+	if err := store.Current().Ping(c.UserContext()); err != nil {
+		checks["store"] = err.Error()
+		ready = false
+	} else {
+		checks["store"] = "ok"
+	}
+
+	if engine, ok := c.App().Config().Views.(*html.Engine); !ok || engine.Templates == nil {
+		checks["templates"] = "not loaded"
+		ready = false
+	} else {
+		checks["templates"] = "ok"
+	}
+
+	if shuttingDown.Load() {
+		checks["shutdown"] = "in progress"
+		ready = false
+	}
+
+	status := "ready"
+	if !ready {
+		status = "not ready"
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(fiber.Map{"status": status, "checks": checks})
+}
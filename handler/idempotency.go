@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Header the idempotency middleware reads, JSON clients can send it themselves
+const IdempotencyKeyHeader = "X-Idempotency-Key"
+
+// Moves the form's idempotency_key into IdempotencyKeyHeader. The key is scoped to the session, so a
+// form left open across a re-login isn't answered with the login page it got the first time.
+func IdempotencyKeyFromForm(c *fiber.Ctx) error {
+	key := c.Get(IdempotencyKeyHeader)
+	if key == "" {
+		key = c.FormValue("idempotency_key")
+	}
+	if key == "" {
+		return c.Next()
+	}
+
+	sum := sha256.Sum256([]byte(c.Cookies("token")))
+	c.Request().Header.Set(IdempotencyKeyHeader, key+"."+hex.EncodeToString(sum[:8]))
+	return c.Next()
+}
+
+// The form key is a UUID, followed by the session part IdempotencyKeyFromForm adds
+func ValidateIdempotencyKey(key string) error {
+	if len(strings.SplitN(key, ".", 2)[0]) != 36 {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid idempotency key")
+	}
+	return nil
+}
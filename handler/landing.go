@@ -1,7 +1,12 @@
 package handler
 
 import (
+	"time"
+
 	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/CRTOsp3ck/mims-app/store"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -19,8 +24,26 @@ func Dashboard(c *fiber.Ctx) error {
 		})
 	}
 
+	now := time.Now()
+	// dont redirect on error, this is the page we would redirect to.. show the dashboard with a warning instead
+	days, err := rollup.Fetch(c, helper.DashboardStartDate(now).Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups for dashboard", "err", err)
+	}
+
+	targets, errTargets := store.Current().ListTargets()
+	if errTargets != nil {
+		logger.FromContext(c.UserContext()).Error("Error loading targets", "err", errTargets)
+	}
+
+	// profit and product revenue only for roles that can open the reports
+	reports := helper.Can(helper.CurrentSession(c).Role, helper.PermReports)
+
 	// Render dashboard within layouts/main
 	return c.Render("dashboard", fiber.Map{
-		"Title": "Dashboard",
+		"Title":          "Dashboard",
+		"Dashboard":      helper.CalculateDashboard(days, now, reports),
+		"TargetProgress": helper.CalculateTargetProgress(targets, days, now),
+		"DatastoreError": err != nil,
 	}, "layouts/main")
 }
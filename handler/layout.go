@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Binds the values layouts/main (and the login page) need on every page, so each handler doesn't have to pass them.
+// Who is logged in and what their role may open (.Can) decide what the sidebar shows.
+func LayoutData(c *fiber.Ctx) error {
+	data := fiber.Map{
+		"PendingSync":   outbox.Current().Pending(),
+		"SyncReview":    len(outbox.Current().Reviews()),
+		"DatastoreDown": !store.Current().Health().Available,
+		// what the sidebar shows, nothing until logged in
+		"Can": helper.Permissions(""),
+		// goes in every form as _csrf
+		"CSRFToken": c.Locals(CSRFLocal),
+		// on every inline <script>, the CSP turns away the ones without it
+		"CSPNonce": c.Locals(CSPNonceLocal),
+	}
+	if sess := helper.CurrentSession(c); sess != nil {
+		data["Identity"] = sess.Identity
+		data["Role"] = sess.Role
+		data["Can"] = helper.Permissions(sess.Role)
+	}
+	c.Bind(data)
+	return c.Next()
+}
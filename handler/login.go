@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/metrics"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+)
+
+var loginAttempts = metrics.NewCounter("mims_login_attempts_total",
+	"Login attempts by result (success, invalid_credentials, disabled, locked, rate_limited, unavailable, error).", "result")
+
+var loginLimits config.Login
+
+// Rate limits and lockout for /auth/login, call once at startup
+func InitLogin(cfg *config.Config) {
+	loginLimits = cfg.Login
+}
+
+// Login page codes that aren't a login result
+const (
+	loginMissing   = "missing"
+	loginIPLimited = "ip_rate_limited"
+)
+
+// What the login page says for each ?error= code, only these are ever shown.
+// %s in the ones that make you wait is how long, from ?wait= in minutes.
+var loginMessages = map[string]string{
+	loginMissing:                  "Enter your identity and password.",
+	model.LoginInvalidCredentials: "Wrong identity or password.",
+	model.LoginDisabled:           "This account is disabled, ask an owner to enable it.",
+	model.LoginLocked:             "Too many wrong passwords, this account is locked for %s.",
+	model.LoginRateLimited:        "Too many sign in attempts for this account, try again in %s.",
+	loginIPLimited:                "Too many sign in attempts from this device, try again in up to %s.",
+	model.LoginUnavailable:        "Can't reach the server right now, try again in a moment.",
+	model.LoginError:              "Something went wrong signing in, try again.",
+}
+
+// The message for the login page's ?error= and ?wait=, empty for a code that isn't in loginMessages.
+// wait can't be made longer than a lockout or limit lasts.
+func loginMessage(code string, wait string) string {
+	message, ok := loginMessages[code]
+	if !ok {
+		return ""
+	}
+	switch code {
+	case model.LoginLocked, model.LoginRateLimited, loginIPLimited:
+		longest := helper.LoginLookback(loginLimits)
+		if loginLimits.IPWindow > longest {
+			longest = loginLimits.IPWindow
+		}
+		minutes, _ := strconv.Atoi(wait)
+		if max := int(math.Ceil(longest.Minutes())); minutes > max {
+			minutes = max
+		}
+		now := time.Now()
+		message = fmt.Sprintf(message, helper.MinutesUntil(now.Add(time.Duration(minutes)*time.Minute), now))
+	}
+	return message
+}
+
+// Per IP address limit on /auth/login, LOGIN_IP_LIMIT tries every LOGIN_IP_WINDOW
+func LoginIPLimit() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        loginLimits.IPLimit,
+		Expiration: loginLimits.IPWindow,
+		LimitReached: func(c *fiber.Ctx) error {
+			recordLoginAttempt(c, c.FormValue("identity"), model.LoginRateLimited, nil)
+			return loginFailedUntil(c, loginIPLimited, time.Now().Add(loginLimits.IPWindow))
+		},
+	})
+}
+
+// Turns the identity away when it's locked out or has been tried too often, false when it may go ahead
+func loginBlocked(c *fiber.Ctx, identity string) (bool, error) {
+	now := time.Now()
+	attempts, err := session.Current().IdentityAttempts(identity, now.Add(-helper.LoginLookback(loginLimits)))
+	if err != nil {
+		// let it through, the store still checks the password
+		logger.FromContext(c.UserContext()).Error("Error reading login attempts", "err", err)
+		return false, nil
+	}
+
+	result, until := helper.LoginBlocked(attempts, loginLimits, now)
+	if result == "" {
+		return false, nil
+	}
+	recordLoginAttempt(c, identity, result, nil)
+	return true, loginFailedUntil(c, result, until)
+}
+
+// Keeps the attempt for the Users page and the lockout, and logs it
+func recordLoginAttempt(c *fiber.Ctx, identity string, result string, err error) {
+	attempt := &model.LoginAttempt{
+		Time:      time.Now(),
+		Identity:  identity,
+		IP:        c.IP(),
+		Result:    result,
+		RequestID: logger.RequestID(c.UserContext()),
+	}
+	if err := session.Current().RecordAttempt(attempt); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error recording login attempt", "err", err)
+	}
+	loginAttempts.Inc(result)
+
+	log := logger.FromContext(c.UserContext())
+	fields := []interface{}{"identity", identity, "ip", attempt.IP, "result", result}
+	if err != nil {
+		fields = append(fields, "err", err)
+	}
+	if result == model.LoginSuccess {
+		log.Info("Login attempt", fields...)
+	} else {
+		log.Warn("Login attempt", fields...)
+	}
+}
+
+// Back to the login page, code is one of loginMessages
+func loginFailed(c *fiber.Ctx, code string) error {
+	return c.Redirect("/main/login?error=" + code)
+}
+
+// Back to the login page with a code that makes you wait, and until when
+func loginFailedUntil(c *fiber.Ctx, code string, until time.Time) error {
+	minutes := int(math.Ceil(time.Until(until).Minutes()))
+	return c.Redirect("/main/login?error=" + code + "&wait=" + strconv.Itoa(minutes))
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func TestLoginMessage(t *testing.T) {
+	loginLimits = config.Login{IPWindow: 5 * time.Minute, IdentityWindow: 15 * time.Minute, Lockout: 30 * time.Minute}
+
+	tests := []struct {
+		code string
+		wait string
+		want string
+	}{
+		{"", "", ""},
+		{model.LoginInvalidCredentials, "", "Wrong identity or password."},
+		{loginMissing, "", "Enter your identity and password."},
+		{model.LoginLocked, "12", "Too many wrong passwords, this account is locked for 12 minutes."},
+		{model.LoginRateLimited, "1", "Too many sign in attempts for this account, try again in a minute."},
+		{loginIPLimited, "4", "Too many sign in attempts from this device, try again in up to 4 minutes."},
+		// nothing but the codes' own text gets on the page
+		{"Your account is suspended, call 555-0100", "", ""},
+		{model.LoginSuccess, "", ""},
+		// and no longer than a lockout lasts
+		{model.LoginLocked, "100000", "Too many wrong passwords, this account is locked for 30 minutes."},
+		{model.LoginLocked, "forever", "Too many wrong passwords, this account is locked for a minute."},
+	}
+	for _, tt := range tests {
+		if got := loginMessage(tt.code, tt.wait); got != tt.want {
+			t.Errorf("loginMessage(%q, %q) = %q, want %q", tt.code, tt.wait, got, tt.want)
+		}
+	}
+}
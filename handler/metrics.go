@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/metrics"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	httpDuration = metrics.NewHistogram("mims_http_request_duration_seconds",
+		"HTTP requests by method, route and status.", metrics.DefaultBuckets, "method", "route", "status")
+
+	salesRecorded = metrics.NewCounter("mims_sales_recorded_total",
+		"Sales recorded through this app (directly or synced from the outbox) by product and payment type.", "product", "payment_type")
+	salesAmount = metrics.NewCounter("mims_sales_amount_total",
+		"Amount of the sales recorded through this app by product and payment type.", "product", "payment_type")
+	salesQty = metrics.NewCounter("mims_sales_qty_total",
+		"Units sold through this app by product.", "product")
+	lastSale = metrics.NewGauge("mims_last_sale_timestamp_seconds",
+		"Unix time of the last sale recorded through this app by operation.", "operation")
+)
+
+var (
+	metricsToken string
+	// behind a proxy on this machine every request comes from loopback, so that can't let anyone in
+	trustLoopback bool
+)
+
+// Registers the sale counters with the store and the gauges read at scrape time, call once after outbox.Init
+func InitMetrics(cfg *config.Config) {
+	metricsToken = cfg.MetricsToken
+	// secure cookies without TLS of our own means a proxy does TLS in front of the app
+	trustLoopback = !cfg.CookieSecure || cfg.TLS.Mode != "off"
+	if metricsToken == "" && !trustLoopback {
+		logger.Warn("Behind a proxy without METRICS_TOKEN, /metrics and /health/datastore refuse every request")
+	}
+	store.OnSaleCreated(recordSale)
+
+	metrics.NewGaugeFunc("mims_outbox_pending",
+		"Sales waiting in the outbox to reach the datastore.", func() float64 {
+			return float64(outbox.Current().Pending())
+		})
+	metrics.NewGaugeFunc("mims_outbox_review",
+		"Queued sales that may already be in the datastore, waiting for someone to check.", func() float64 {
+			return float64(len(outbox.Current().Reviews()))
+		})
+	metrics.NewGaugeFunc("mims_datastore_up",
+		"1 while the store is available (circuit breaker not open), 0 otherwise.", func() float64 {
+			if store.Current().Health().Available {
+				return 1
+			}
+			return 0
+		})
+}
+
+func recordSale(sale model.JsonSale) {
+	product, err := helper.ParseItemToString(sale.ItemID)
+	if err != nil {
+		product = "Unknown"
+	}
+	payment, err := helper.ParsePaymentMethodToString(sale.PaymentType)
+	if err != nil {
+		payment = "Unknown"
+	}
+	operation, err := helper.ParseOperationToString(sale.OperationID)
+	if err != nil {
+		operation = strconv.Itoa(sale.OperationID)
+	}
+
+	salesRecorded.Inc(product, payment)
+	salesAmount.Add(float64(sale.Amount), product, payment)
+	salesQty.Add(float64(sale.Qty), product)
+	lastSale.Set(float64(sale.CreatedAt.Unix()), operation)
+}
+
+// Methods that get their own label, anything else a client makes up is "other"
+var metricMethods = map[string]bool{
+	fiber.MethodGet: true, fiber.MethodHead: true, fiber.MethodPost: true, fiber.MethodPut: true, fiber.MethodPatch: true,
+	fiber.MethodDelete: true, fiber.MethodOptions: true, fiber.MethodConnect: true, fiber.MethodTrace: true,
+}
+
+// Times every request for mims_http_request_duration_seconds
+func RequestMetrics(c *fiber.Ctx) error {
+	started := time.Now()
+	err := c.Next()
+	httpDuration.Observe(time.Since(started).Seconds(), metricMethod(c.Method()), c.Route().Path, strconv.Itoa(responseStatus(c, err)))
+	return err
+}
+
+func metricMethod(method string) string {
+	if metricMethods[method] {
+		return method
+	}
+	return "other"
+}
+
+// Guards /metrics and /health/datastore, there are sales figures in them. With METRICS_TOKEN set the
+// request needs it as "Authorization: Bearer <token>", without it only requests from this machine get in
+// (and none at all behind a proxy, where they all look like they are from this machine).
+func MonitoringAuth(c *fiber.Ctx) error {
+	if metricsToken != "" {
+		if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), []byte("Bearer "+metricsToken)) == 1 {
+			return c.Next()
+		}
+	} else if ip := net.ParseIP(c.IP()); trustLoopback && ip != nil && ip.IsLoopback() {
+		return c.Next()
+	}
+
+	logger.FromContext(c.UserContext()).Warn("Monitoring request refused", "path", c.Path(), "ip", c.IP())
+	if metricsToken != "" {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Bearer token required"})
+	}
+	if !trustLoopback {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Set METRICS_TOKEN to scrape from behind a proxy"})
+	}
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only from this machine, set METRICS_TOKEN to scrape from elsewhere"})
+}
+
+// Prometheus metrics (text format)
+func Metrics(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Write(c.Response().BodyWriter())
+	return nil
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMonitoringAuth(t *testing.T) {
+	// the test request's address comes from X-Real-IP
+	app := fiber.New(fiber.Config{ProxyHeader: "X-Real-IP"})
+	app.Get("/metrics", MonitoringAuth, func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+
+	tests := []struct {
+		name          string
+		token         string
+		trustLoopback bool
+		ip            string
+		auth          string
+		want          int
+	}{
+		{"this machine", "", true, "127.0.0.1", "", fiber.StatusOK},
+		{"this machine over ipv6", "", true, "::1", "", fiber.StatusOK},
+		{"another machine", "", true, "192.168.1.30", "", fiber.StatusForbidden},
+		// everything comes from the proxy
+		{"behind a proxy", "", false, "127.0.0.1", "", fiber.StatusForbidden},
+		{"token", "s3cret", false, "192.168.1.30", "Bearer s3cret", fiber.StatusOK},
+		{"wrong token", "s3cret", true, "192.168.1.30", "Bearer nope", fiber.StatusUnauthorized},
+		{"token needed from this machine too", "s3cret", true, "127.0.0.1", "", fiber.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricsToken, trustLoopback = tt.token, tt.trustLoopback
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			req.Header.Set("X-Real-IP", tt.ip)
+			if tt.auth != "" {
+				req.Header.Set(fiber.HeaderAuthorization, tt.auth)
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricMethod(t *testing.T) {
+	for method, want := range map[string]string{"GET": "GET", "POST": "POST", "PROPFIND": "other", "X-RANDOM-1234": "other"} {
+		if got := metricMethod(method); got != want {
+			t.Errorf("metricMethod(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/gofiber/fiber/v2"
+)
+
+// A queued sale waiting for review, next to the datastore sale that looks like it
+type syncReviewRow struct {
+	OutboxID string
+	QueuedAt string
+	Sale     model.ViewSale
+	MatchID  uint64
+}
+
+// Queued sales that may already be in the datastore, the sales history shows the matching sale
+func SyncReview(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	rows := []syncReviewRow{}
+	for _, e := range outbox.Current().Reviews() {
+		paymentType, _ := helper.ParsePaymentMethodToString(e.Sale.PaymentType)
+		operation, _ := helper.ParseOperationToString(e.Sale.OperationID)
+		item, _ := helper.ParseItemToString(e.Sale.ItemID)
+		rows = append(rows, syncReviewRow{
+			OutboxID: e.ID,
+			QueuedAt: e.QueuedAt.Local().Format("2006-01-02 15:04:05"),
+			Sale: model.ViewSale{
+				Amount:      "RM" + strconv.FormatFloat(float64(e.Sale.Amount), 'f', -1, 64),
+				Qty:         strconv.FormatFloat(float64(e.Sale.Qty), 'f', -1, 64) + " unit(s)",
+				PaymentType: paymentType,
+				Operation:   operation,
+				Item:        item,
+			},
+			MatchID: e.MatchID,
+		})
+	}
+	errorMessage, successMessage := takeFlash(c)
+
+	//pass it to the renderer
+	return c.Render("sync-review", fiber.Map{
+		"Title":   "Sync Review",
+		"Reviews": rows,
+		"Error":   errorMessage,
+		"Success": successMessage,
+	}, "layouts/main")
+}
+
+// action is "landed" when the datastore's sale is this one, "send" to send it anyway
+func SyncReviewRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	id := c.Params("id")
+	action := c.FormValue("action")
+	if action != "landed" && action != "send" {
+		return syncReviewRedirect(c, "error", "Pick whether the sale is already in the datastore")
+	}
+
+	err := outbox.Current().Resolve(id, action == "landed")
+	if errors.Is(err, outbox.ErrNotFound) {
+		return syncReviewRedirect(c, "error", err.Error())
+	}
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error settling queued sale", "outbox_id", id, "err", err)
+		return syncReviewRedirect(c, "error", "Something went wrong, try again")
+	}
+	logger.FromContext(c.UserContext()).Info("Queued sale reviewed", "outbox_id", id, "action", action, "by", helper.CurrentSession(c).Identity)
+
+	if action == "landed" {
+		return syncReviewRedirect(c, "success", "Dropped the queued sale, the datastore already has it")
+	}
+	return syncReviewRedirect(c, "success", "The queued sale will be sent to the datastore")
+}
+
+func syncReviewRedirect(c *fiber.Ctx, kind string, message string) error {
+	setFlash(c, kind, message)
+	return c.Redirect("/main/sync-review")
+}
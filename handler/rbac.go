@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Lets the request through only when the caller's role has permission (see helper.Can).
+// Not logged in gets the login page, like the handlers do; logged in without permission gets a 403.
+// Until a forced password change is done, everything sends the caller to the change password page.
+func Require(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !helper.CheckAuthState(c) {
+			return c.Render("login", fiber.Map{
+				"Title": "Login",
+			})
+		}
+
+		sess := helper.CurrentSession(c)
+		if sess.MustChangePassword {
+			return PasswordChangeRequired(c)
+		}
+		if helper.Can(sess.Role, permission) {
+			return c.Next()
+		}
+
+		logger.FromContext(c.UserContext()).Warn("Permission denied", "identity", sess.Identity, "role", sess.Role, "permission", permission, "path", c.Path())
+		c.Status(fiber.StatusForbidden)
+		if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+			return c.JSON(fiber.Map{"error": "Your role can't do this"})
+		}
+		return c.Render("forbidden", fiber.Map{
+			"Title": "Not Allowed",
+		}, "layouts/main")
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Locals key the requestid middleware keeps the ID under, set it in its config
+const RequestIDLocal = "requestid"
+
+// Puts the request ID from the requestid middleware into the user context (for the store calls and
+// handler logs) and logs every request once it's done with its route, status, duration and who made it
+func RequestLog(c *fiber.Ctx) error {
+	id, _ := c.Locals(RequestIDLocal).(string)
+	c.SetUserContext(logger.WithRequestID(c.UserContext(), id))
+
+	started := time.Now()
+	err := c.Next()
+
+	status := responseStatus(c, err)
+
+	fields := []interface{}{"method", c.Method(), "path", c.Path(), "route", c.Route().Path, "status", status, "duration_ms", logger.Millis(started), "ip", c.IP()}
+	// who made it, when they're logged in (usually already looked up by the handler)
+	if c.Cookies("token") != "" {
+		if sess := helper.CurrentSession(c); sess != nil {
+			fields = append(fields, "identity", sess.Identity)
+		}
+	}
+	if err != nil {
+		fields = append(fields, "err", err)
+	}
+
+	log := logger.FromContext(c.UserContext())
+	switch {
+	case status >= 500:
+		log.Error("Request", fields...)
+	case status >= 400:
+		log.Warn("Request", fields...)
+	case strings.HasPrefix(c.Path(), "/static") || c.Path() == "/healthz" || c.Path() == "/readyz" || c.Path() == "/metrics":
+		// probes, scrapes and assets would drown everything else
+		log.Debug("Request", fields...)
+	default:
+		log.Info("Request", fields...)
+	}
+	return err
+}
+
+// Status the client gets, also when a handler returned an error the error handler hasn't written yet
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var e *fiber.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return fiber.StatusInternalServerError
+}
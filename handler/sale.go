@@ -1,18 +1,19 @@
 package handler
 
 import (
-	"encoding/json"
-	"io"
-	"log"
-	"net/http"
+	"errors"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/CRTOsp3ck/mims-app/config"
 	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
 	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/CRTOsp3ck/mims-app/store"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 )
 
 func NewSale(c *fiber.Ctx) error {
@@ -22,8 +23,12 @@ func NewSale(c *fiber.Ctx) error {
 		})
 	}
 
+	// no-store so going back to the form always gets a fresh key instead of replaying the last sale
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
 	return c.Render("new-sale", fiber.Map{
-		"Title": "New Sale",
+		"Title":          "New Sale",
+		"IdempotencyKey": utils.UUIDv4(),
 	}, "layouts/main")
 }
 
@@ -39,58 +44,45 @@ func NewSaleRequest(c *fiber.Ctx) error {
 		return err
 	}
 
-	var amt int
-	var qty int
-	var url string
-	var itemId int
-	var groupSaleId int
 	paymentType, _ := helper.ParsePaymentMethodToInt(ns.PaymentMethod)
 	operationId := 1
-	groupSaleId = 0
+	groupSaleId := 0
 
 	//i need to change DB structure to accommodate ever growing product list.
 	//this is hardcoded now, since we only selling 1 product. Its ok for now...
-	if ns.Qty_FreshJuice > 0 {
-		amt = ns.Qty_FreshJuice * 8
-		qty = ns.Qty_FreshJuice
-		itemId = 1
-		url = config.Config("API_SERVER_ADDR") + "/sa/new/" +
-			strconv.Itoa(amt) + "-" + strconv.Itoa(qty) + "-" + strconv.Itoa(paymentType) + "-" + strconv.Itoa(operationId) + "-" + strconv.Itoa(itemId) + "-" + strconv.Itoa(groupSaleId)
-	}
-
-	req, err := http.NewRequest(http.MethodPost, url, nil)
-	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
+	if ns.Qty_FreshJuice <= 0 {
+		//redirect back to /main/new-sale w/ toast saying nothing to register
 		return c.Redirect("/main/new-sale")
 	}
 
-	// add authorization header to the req
-	bearer := "Bearer " + c.Cookies("token")
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
-
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/new-sale")
-	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	body, err := io.ReadAll(res.Body)
-	_ = body // i should return the body
-	if err != nil {
-		log.Println("Error reading response body -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/new-sale")
+	sale := &model.JsonSale{
+		Amount:      float32(ns.Qty_FreshJuice * 8),
+		Qty:         float32(ns.Qty_FreshJuice),
+		PaymentType: paymentType,
+		OperationID: operationId,
+		ItemID:      1,
+		GroupSaleID: groupSaleId,
+	}
+
+	token := c.Cookies("token")
+	identity := helper.CurrentSession(c).Identity
+	if err := store.Current().CreateSale(c.UserContext(), token, sale); err != nil {
+		// datastore unreachable, keep the sale in the outbox and let the worker send it later
+		var unavailable *store.UnavailableError
+		if !errors.As(err, &unavailable) {
+			logger.FromContext(c.UserContext()).Error("Error creating sale", "err", err)
+			//redirect back to /main/new-sale w/ toast saying error occured
+			return c.Redirect("/main/new-sale")
+		}
+		if err := outbox.Current().Enqueue(c.UserContext(), identity, token, sale, unavailable.Sent); err != nil {
+			logger.FromContext(c.UserContext()).Error("Error queueing sale", "err", err)
+			return c.Redirect("/main/new-sale")
+		}
+		return c.Redirect("/main/sales-history")
 	}
+	// datastore is back, anything they queued can go now
+	logger.FromContext(c.UserContext()).Info("Sale created", "operation_id", sale.OperationID, "item_id", sale.ItemID, "qty", sale.Qty, "amount", sale.Amount, "payment_type", sale.PaymentType)
+	outbox.Current().Adopt(identity, token)
 
 	//redirect to /main/sales-history w/ toast saying sale successfully registered
 	//after i create the toast, i can always redirect to "/main/new-sale" if need be instead of "/main/sales-history"
@@ -105,51 +97,34 @@ func SalesHistory(c *fiber.Ctx) error {
 		})
 	}
 
-	url := config.Config("API_SERVER_ADDR") + "/sa/find/"
-
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
+	// drill-down from the sales report (?operation=&item=&sd=&ed=)
+	filter := new(model.SalesHistoryFilter)
+	if err := c.QueryParser(filter); err != nil {
+		logger.FromContext(c.UserContext()).Warn("Error parsing sales history filter", "err", err)
 		return c.Redirect("/main/sales-history")
 	}
-
-	// add authorization header to the req
-	bearer := "Bearer " + c.Cookies("token")
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
-
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/sales-history")
+	// they end up in the datastore's url
+	for _, date := range []string{filter.StartDate, filter.EndDate} {
+		if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+			logger.FromContext(c.UserContext()).Warn("Invalid sales history date", "date", date)
+			return c.Redirect("/main/sales-history")
+		}
 	}
 
-	if res.Body != nil {
-		defer res.Body.Close()
+	// cashiers only see today's sales
+	if sess := helper.CurrentSession(c); !helper.Can(sess.Role, helper.PermSalesHistoryAll) {
+		today := time.Now().Format("2006-01-02")
+		filter.StartDate, filter.EndDate = today, today
 	}
 
-	body, err := io.ReadAll(res.Body)
+	sales, err := helper.FetchSales(c, filter.StartDate, filter.EndDate)
 	if err != nil {
-		log.Println("Error reading response body -", err)
+		logger.FromContext(c.UserContext()).Error("Error fetching sales", "err", err)
 		//redirect back to /main/new-sale w/ toast saying error occured
 		return c.Redirect("/main/sales-history")
 	}
 
-	// log.Println("Body -", string(body))
-	var sales []model.JsonSale
-
-	//convert that string (body) to json
-	if err := json.Unmarshal(body, &sales); err != nil {
-		log.Println("Error unmarshalling body into JSON -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/sales-history")
-	}
+	sales = helper.FilterSales(sales, *filter)
 
 	//create an array of view sales with that json information..
 	viewSales := []*model.ViewSale{}
@@ -180,8 +155,9 @@ func SalesHistory(c *fiber.Ctx) error {
 
 	//pass it to the renderer
 	return c.Render("sales-history", fiber.Map{
-		"Title": "Sales History",
-		"Sales": viewSales,
+		"Title":  "Sales History",
+		"Sales":  viewSales,
+		"Filter": filter,
 	}, "layouts/main")
 }
 
@@ -192,85 +168,47 @@ func SalesReport(c *fiber.Ctx) error {
 		})
 	}
 
-	url := config.Config("API_SERVER_ADDR") + "/sa/find/"
-
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	lifetime, err := rollup.FetchLifetime(c)
 	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups", "err", err)
 		return c.Redirect("/main/sales-report")
 	}
 
-	// add authorization header to the req
-	bearer := "Bearer " + c.Cookies("token")
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
+	// Lifetime VSR
+	lifetimeVsr := helper.CalculateSalesReport(lifetime)
+	lifetimePayments := helper.CalculatePaymentBreakdown(lifetime)
 
-	res, err := client.Do(req)
+	// Targets for the current day/month
+	now := time.Now()
+	days, err := rollup.Fetch(c, helper.BucketStart(now, helper.BucketMonth).Format("2006-01-02"), now.Format("2006-01-02"))
 	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups", "err", err)
 		return c.Redirect("/main/sales-report")
 	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	body, err := io.ReadAll(res.Body)
-	_ = body
+	targets, err := store.Current().ListTargets()
 	if err != nil {
-		log.Println("Error reading response body -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
-
-	var jsonSales struct {
-		Sales []model.JsonSale `json:"sales"`
-	}
-
-	//convert that string (body) to json
-	if err := json.Unmarshal(body, &jsonSales.Sales); err != nil {
-		log.Println("Error unmarshalling body to json -", err)
-		return c.Redirect("/main/sales-report")
+		logger.FromContext(c.UserContext()).Error("Error loading targets", "err", err)
 	}
-
-	// Lifetime VSR
-	lifetimeVsr := model.ViewSalesReport{}
-
-	// calcuating all the revenue of every sale ever made...
-	// i shouldnt be iterating as below
-	// not efficient. lets start thinking of this when shit hits the fan
-	for index := range jsonSales.Sales {
-		lifetimeVsr.TotalGrossRevenue += float64(jsonSales.Sales[index].Amount)
-	}
-
-	lifetimeVsr.TotalExpenses = helper.RoundTo(0.00, 2)
-	lifetimeVsr.TotalNetRevenue = helper.RoundTo(lifetimeVsr.TotalGrossRevenue-lifetimeVsr.TotalExpenses, 2)
-	lifetimeVsr.IncomeTax = helper.RoundTo(lifetimeVsr.TotalNetRevenue*0.12, 2)
-	lifetimeVsr.GrantLoan = helper.RoundTo(0.00, 2)
-	lifetimeVsr.ProfitLoss = helper.RoundTo(lifetimeVsr.TotalGrossRevenue+lifetimeVsr.GrantLoan-lifetimeVsr.TotalExpenses-lifetimeVsr.IncomeTax, 2)
+	targetProgress := helper.CalculateTargetProgress(targets, days, now)
 
 	// Periodic VSR
 	// It will be the same as lifetime when page loads
 	// maybe i should set the default range as the start of that current month until the last day of operation in that month
-	periodicVsr := model.ViewSalesReport{}
-	periodicVsr.TotalGrossRevenue = lifetimeVsr.TotalGrossRevenue
-	periodicVsr.TotalExpenses = lifetimeVsr.TotalExpenses
-	periodicVsr.TotalNetRevenue = lifetimeVsr.TotalNetRevenue
-	periodicVsr.IncomeTax = lifetimeVsr.IncomeTax
-	periodicVsr.GrantLoan = lifetimeVsr.GrantLoan
-	periodicVsr.ProfitLoss = lifetimeVsr.ProfitLoss
+	periodicVsr := lifetimeVsr
+	periodicPayments := lifetimePayments
+	periodicOperations := helper.CalculateOperationBreakdown(lifetime)
+	periodicItems := helper.CalculateItemBreakdown(lifetime)
 
 	//pass it to the renderer
 	return c.Render("sales-report", fiber.Map{
-		"Title":       "Sales Analysis",
-		"LifetimeVsr": lifetimeVsr,
-		"PeriodicVsr": periodicVsr,
+		"Title":              "Sales Analysis",
+		"LifetimeVsr":        lifetimeVsr,
+		"PeriodicVsr":        periodicVsr,
+		"LifetimePayments":   lifetimePayments,
+		"PeriodicPayments":   periodicPayments,
+		"PeriodicOperations": periodicOperations,
+		"PeriodicItems":      periodicItems,
+		"TargetProgress":     targetProgress,
 	}, "layouts/main")
 }
 
@@ -284,7 +222,7 @@ func SalesReportUpdatePeriodic(c *fiber.Ctx) error {
 	d := new(model.Dates)
 	// parse body into struct
 	if err := c.BodyParser(d); err != nil {
-		log.Println("Error parsing dates into struct -", err)
+		logger.FromContext(c.UserContext()).Warn("Error parsing dates into struct", "err", err)
 		return err
 	}
 
@@ -292,138 +230,86 @@ func SalesReportUpdatePeriodic(c *fiber.Ctx) error {
 	d.StartDate = strings.Split(d.StartDate, " ")[0]
 	d.EndDate = strings.Split(d.EndDate, " ")[0]
 
-	//Fetch from API Server for Periodic VSR
-	//follow the api specification from mims-datastore
-	url := config.Config("API_SERVER_ADDR") + "/sa/find/" + d.StartDate + "-" + d.EndDate
-
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/sales-report w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
-
-	// add authorization header to the req
-	bearer := "Bearer " + c.Cookies("token")
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
-
-	res, err := client.Do(req)
+	// Every day rolled up, the periodic range, targets and comparisons all come out of it
+	days, err := rollup.Fetch(c, "", "")
 	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups", "err", err)
 		//redirect back to /main/sales-report w/ toast saying error occured
 		return c.Redirect("/main/sales-report")
 	}
 
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	body, err := io.ReadAll(res.Body)
-	_ = body
-	if err != nil {
-		log.Println("Error reading response body -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
-
-	var jsonSales struct {
-		Sales []model.JsonSale `json:"sales"`
-	}
-
-	//convert that string (body) to json
-	if err := json.Unmarshal(body, &jsonSales.Sales); err != nil {
-		log.Println("Error unmarshalling body into JSON (lifetime) -", err)
-		//redirect back to /main/new-sale w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
-
 	// Periodic VSR
-	periodicVsr := model.ViewSalesReport{}
+	periodic := helper.MergeRollups(helper.RollupsBetween(days, d.StartDate, d.EndDate))
+	periodicVsr := helper.CalculateSalesReport(periodic)
+	periodicPayments := helper.CalculatePaymentBreakdown(periodic)
+	periodicOperations := helper.CalculateOperationBreakdown(periodic)
+	periodicItems := helper.CalculateItemBreakdown(periodic)
 
-	// calcuating all the revenue of every sale ever made...
-	// i shouldnt be iterating as below
-	// not efficient. lets start thinking of this when shit hits the fan
-	for index := range jsonSales.Sales {
-		periodicVsr.TotalGrossRevenue += float64(jsonSales.Sales[index].Amount)
-	}
-
-	periodicVsr.TotalExpenses = helper.RoundTo(0.00, 2)
-	periodicVsr.TotalNetRevenue = helper.RoundTo(periodicVsr.TotalGrossRevenue-periodicVsr.TotalExpenses, 2)
-	periodicVsr.IncomeTax = helper.RoundTo(periodicVsr.TotalNetRevenue*0.12, 2)
-	periodicVsr.GrantLoan = helper.RoundTo(0.00, 2)
-	periodicVsr.ProfitLoss = helper.RoundTo(periodicVsr.TotalGrossRevenue+periodicVsr.GrantLoan-periodicVsr.TotalExpenses-periodicVsr.IncomeTax, 2)
-
-	// Fetch from API Server for Lifetime VSR
-	url = config.Config("API_SERVER_ADDR") + "/sa/find/"
-
-	client = http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
-	}
-
-	req, err = http.NewRequest(http.MethodGet, url, nil)
+	// Lifetime VSR
+	lifetime, err := rollup.FetchLifetime(c)
 	if err != nil {
-		log.Println("Post request not completed -", err)
-		//redirect back to /main/sales-report w/ toast saying error occured
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups", "err", err)
 		return c.Redirect("/main/sales-report")
 	}
+	lifetimeVsr := helper.CalculateSalesReport(lifetime)
+	lifetimePayments := helper.CalculatePaymentBreakdown(lifetime)
 
-	// add authorization header to the req
-	bearer = "Bearer " + c.Cookies("token")
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
-
-	res, err = client.Do(req)
+	// Targets for the current day/month
+	targets, err := store.Current().ListTargets()
 	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		//redirect back to /main/sales-report w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
+		logger.FromContext(c.UserContext()).Error("Error loading targets", "err", err)
+	}
+	targetProgress := helper.CalculateTargetProgress(targets, days, time.Now())
+
+	// Compare with the previous equivalent period and the same period last year
+	comparisons := []*model.ViewPeriodComparison{}
+	start, errStart := time.ParseInLocation("2006-01-02", d.StartDate, time.Local)
+	end, errEnd := time.ParseInLocation("2006-01-02", d.EndDate, time.Local)
+	if errStart == nil && errEnd == nil {
+		prevStart, prevEnd := helper.PreviousPeriod(start, end)
+		lastYearStart, lastYearEnd := helper.SamePeriodLastYear(start, end)
+		periods := []struct {
+			label string
+			start time.Time
+			end   time.Time
+		}{
+			{"Previous period", prevStart, prevEnd},
+			{"Same period last year", lastYearStart, lastYearEnd},
+		}
+		for _, p := range periods {
+			previous := helper.MergeRollups(helper.RollupsBetween(days, p.start.Format("2006-01-02"), p.end.Format("2006-01-02")))
+			comparisons = append(comparisons, helper.ComparePeriods(p.label, p.start, p.end, periodic, previous))
+		}
+	} else {
+		logger.FromContext(c.UserContext()).Warn("Error parsing periodic dates for comparison", "start_err", errStart, "end_err", errEnd)
 	}
 
-	body, err = io.ReadAll(res.Body)
-	_ = body
-	if err != nil {
-		log.Println("Error reading response body -", err)
-		//redirect back to /main/sales-report w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
-	}
+	//pass it to the renderer
+	return c.Render("sales-report", fiber.Map{
+		"Title":              "Sales Analysis",
+		"Comparisons":        comparisons,
+		"PeriodicVsr":        periodicVsr,
+		"LifetimeVsr":        lifetimeVsr,
+		"PeriodicPayments":   periodicPayments,
+		"LifetimePayments":   lifetimePayments,
+		"PeriodicOperations": periodicOperations,
+		"PeriodicItems":      periodicItems,
+		"TargetProgress":     targetProgress,
+		"Dates":              d,
+	}, "layouts/main")
+}
 
-	//convert that string (body) to json
-	if err := json.Unmarshal(body, &jsonSales.Sales); err != nil {
-		log.Println("Error unmarshalling body into JSON (periodic) -", err)
-		//redirect back to /main/sales-report w/ toast saying error occured
-		return c.Redirect("/main/sales-report")
+// Rolls every sale up again, for when the daily totals look off
+func SalesReportRebuildRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
 	}
 
-	// Lifetime VSR
-	lifetimeVsr := model.ViewSalesReport{}
-
-	// calcuating all the revenue of every sale ever made...
-	// i shouldnt be iterating as below
-	// not efficient. lets start thinking of this when shit hits the fan
-	for index := range jsonSales.Sales {
-		lifetimeVsr.TotalGrossRevenue += float64(jsonSales.Sales[index].Amount)
+	if err := rollup.Current().Rebuild(c.UserContext(), c.Cookies("token")); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error rebuilding rollups", "err", err)
 	}
 
-	lifetimeVsr.TotalExpenses = helper.RoundTo(0.00, 2)
-	lifetimeVsr.TotalNetRevenue = helper.RoundTo(lifetimeVsr.TotalGrossRevenue-lifetimeVsr.TotalExpenses, 2)
-	lifetimeVsr.IncomeTax = helper.RoundTo(lifetimeVsr.TotalNetRevenue*0.12, 2)
-	lifetimeVsr.GrantLoan = helper.RoundTo(0.00, 2)
-	lifetimeVsr.ProfitLoss = helper.RoundTo(lifetimeVsr.TotalGrossRevenue+lifetimeVsr.GrantLoan-lifetimeVsr.TotalExpenses-lifetimeVsr.IncomeTax, 2)
-
-	//pass it to the renderer
-	return c.Render("sales-report", fiber.Map{
-		"Title":       "Sales Analysis",
-		"PeriodicVsr": periodicVsr,
-		"LifetimeVsr": lifetimeVsr,
-		"Dates":       d,
-	}, "layouts/main")
+	return c.Redirect("/main/sales-report")
 }
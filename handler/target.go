@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+func Targets(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	targets, err := store.Current().ListTargets()
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error loading targets", "err", err)
+	}
+
+	now := time.Now()
+	days, err := rollup.Fetch(c, helper.BucketStart(now, helper.BucketMonth).Format("2006-01-02"), now.Format("2006-01-02"))
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error fetching rollups for targets", "err", err)
+	}
+
+	errorMessage, _ := takeFlash(c)
+
+	//pass it to the renderer
+	return c.Render("targets", fiber.Map{
+		"Title":          "Targets",
+		"TargetProgress": helper.CalculateTargetProgress(targets, days, now),
+		"Error":          errorMessage,
+	}, "layouts/main")
+}
+
+func NewTargetRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	t := new(model.Target)
+	if err := c.BodyParser(t); err != nil {
+		logger.FromContext(c.UserContext()).Warn("Error parsing target", "err", err)
+		return targetsError(c, "Unable to read target")
+	}
+
+	if err := helper.ValidateTarget(t); err != nil {
+		return targetsError(c, err.Error())
+	}
+
+	if err := store.Current().CreateTarget(t); err != nil {
+		// the details are in the log
+		logger.FromContext(c.UserContext()).Error("Error creating target", "err", err)
+		return targetsError(c, "Unable to save target, try again")
+	}
+
+	return c.Redirect("/main/targets")
+}
+
+func DeleteTargetRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Redirect("/main/targets")
+	}
+
+	if err := store.Current().DeleteTarget(id); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error deleting target", "err", err)
+		return targetsError(c, "Unable to delete target, try again")
+	}
+
+	return c.Redirect("/main/targets")
+}
+
+// Back to the Targets page with message
+func targetsError(c *fiber.Ctx, message string) error {
+	setFlash(c, "error", message)
+	return c.Redirect("/main/targets")
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Sends plain http requests to the same host over https on port (left out of the URL when it's 443).
+// A temporary redirect, so turning TLS off later doesn't leave browsers stuck on a cached one; HSTS is what makes them remember.
+func HTTPSRedirect(port int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		host := c.Hostname()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		return c.Redirect("https://"+host+c.OriginalURL(), fiber.StatusTemporaryRedirect)
+	}
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Staff accounts, owners only
+func Users(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	users, err := store.Current().ListUsers()
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error loading users", "err", err)
+	}
+	attempts, err := session.Current().RecentAttempts(50)
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error loading login attempts", "err", err)
+	}
+
+	errorMessage, successMessage := takeFlash(c)
+
+	//pass it to the renderer
+	return c.Render("users", fiber.Map{
+		"Title":             "Users",
+		"Users":             users,
+		"LoginAttempts":     attempts,
+		"Roles":             helper.Roles,
+		"MinPasswordLength": helper.MinPasswordLength,
+		"Self":              helper.CurrentSession(c).Identity,
+		"Error":             errorMessage,
+		"Success":           successMessage,
+	}, "layouts/main")
+}
+
+func NewUserRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	form := new(model.FormUser)
+	if err := c.BodyParser(form); err != nil {
+		logger.FromContext(c.UserContext()).Warn("Error parsing user", "err", err)
+		return usersRedirect(c, "error", "Unable to read user")
+	}
+	if err := helper.ValidateNewUser(form); err != nil {
+		return usersRedirect(c, "error", err.Error())
+	}
+
+	by := helper.CurrentSession(c).Identity
+	user := &model.User{Identity: form.Identity, Role: form.Role}
+	if err := store.Current().CreateUser(c.UserContext(), c.Cookies("token"), by, user, form.Password); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error creating user", "identity", form.Identity, "err", err)
+		return usersRedirect(c, "error", userErrorMessage(err))
+	}
+	logger.FromContext(c.UserContext()).Info("User created", "identity", user.Identity, "role", user.Role, "by", by)
+
+	return usersRedirect(c, "success", "Created "+user.Identity+", they pick their own password on first login")
+}
+
+func DisableUserRequest(c *fiber.Ctx) error {
+	return setUserDisabled(c, true)
+}
+
+func EnableUserRequest(c *fiber.Ctx) error {
+	return setUserDisabled(c, false)
+}
+
+func setUserDisabled(c *fiber.Ctx, disabled bool) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	identity := c.FormValue("identity")
+	by := helper.CurrentSession(c).Identity
+	if identity == by {
+		return usersRedirect(c, "error", "You can't disable your own account")
+	}
+
+	if err := store.Current().SetUserDisabled(c.UserContext(), c.Cookies("token"), by, identity, disabled); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error updating user", "identity", identity, "err", err)
+		return usersRedirect(c, "error", userErrorMessage(err))
+	}
+
+	if !disabled {
+		logger.FromContext(c.UserContext()).Info("User enabled", "identity", identity, "by", by)
+		return usersRedirect(c, "success", "Enabled "+identity)
+	}
+	// out of the app right away, not when their session runs out
+	if err := session.Current().DeleteIdentity(identity); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error ending sessions", "identity", identity, "err", err)
+	}
+	logger.FromContext(c.UserContext()).Info("User disabled", "identity", identity, "by", by)
+	return usersRedirect(c, "success", "Disabled "+identity)
+}
+
+func ResetPasswordRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	identity := c.FormValue("identity")
+	password := c.FormValue("password")
+	by := helper.CurrentSession(c).Identity
+	if identity == by {
+		return usersRedirect(c, "error", "Change your own password from the profile menu")
+	}
+	if err := helper.ValidatePassword(password, identity); err != nil {
+		return usersRedirect(c, "error", err.Error())
+	}
+
+	if err := store.Current().ResetPassword(c.UserContext(), c.Cookies("token"), by, identity, password); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error resetting password", "identity", identity, "err", err)
+		return usersRedirect(c, "error", userErrorMessage(err))
+	}
+	// logged in with the old password, they log in again with the temporary one
+	if err := session.Current().DeleteIdentity(identity); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error ending sessions", "identity", identity, "err", err)
+	}
+	logger.FromContext(c.UserContext()).Info("Password reset", "identity", identity, "by", by)
+
+	return usersRedirect(c, "success", "Password reset, "+identity+" picks a new one on next login")
+}
+
+// Change password page, for everyone logged in
+func ChangePassword(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	errorMessage, successMessage := takeFlash(c)
+	return c.Render("change-password", fiber.Map{
+		"Title":             "Change Password",
+		"Forced":            helper.CurrentSession(c).MustChangePassword,
+		"MinPasswordLength": helper.MinPasswordLength,
+		"Error":             errorMessage,
+		"Success":           successMessage,
+	}, "layouts/main")
+}
+
+func ChangePasswordRequest(c *fiber.Ctx) error {
+	if !helper.CheckAuthState(c) {
+		return c.Render("login", fiber.Map{
+			"Title": "Login",
+		})
+	}
+
+	form := new(model.FormPasswordChange)
+	if err := c.BodyParser(form); err != nil {
+		logger.FromContext(c.UserContext()).Warn("Error parsing password change", "err", err)
+		return passwordRedirect(c, "error", "Unable to read the form")
+	}
+
+	sess := helper.CurrentSession(c)
+	if form.NewPassword != form.ConfirmPassword {
+		return passwordRedirect(c, "error", "The new passwords don't match")
+	}
+	if form.NewPassword == form.CurrentPassword {
+		return passwordRedirect(c, "error", "Pick a password different from the current one")
+	}
+	if err := helper.ValidatePassword(form.NewPassword, sess.Identity); err != nil {
+		return passwordRedirect(c, "error", err.Error())
+	}
+
+	err := store.Current().ChangePassword(c.UserContext(), c.Cookies("token"), sess.Identity, form.CurrentPassword, form.NewPassword)
+	if errors.Is(err, store.ErrInvalidCredentials) {
+		logger.FromContext(c.UserContext()).Warn("Wrong current password on password change", "identity", sess.Identity)
+		return passwordRedirect(c, "error", "Current password is wrong")
+	}
+	if err != nil {
+		logger.FromContext(c.UserContext()).Error("Error changing password", "identity", sess.Identity, "err", err)
+		return passwordRedirect(c, "error", userErrorMessage(err))
+	}
+
+	if err := session.Current().PasswordChanged(c.Cookies("token")); err != nil {
+		logger.FromContext(c.UserContext()).Error("Error updating session", "err", err)
+	}
+	logger.FromContext(c.UserContext()).Info("Password changed", "identity", sess.Identity)
+
+	if sess.MustChangePassword {
+		return c.Redirect("/main")
+	}
+	return passwordRedirect(c, "success", "Password changed")
+}
+
+// What Require answers while the caller still has to change their password
+func PasswordChangeRequired(c *fiber.Ctx) error {
+	if c.Accepts(fiber.MIMETextHTML, fiber.MIMEApplicationJSON) == fiber.MIMEApplicationJSON {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Change your password first"})
+	}
+	return c.Redirect("/main/account/password")
+}
+
+// Store errors worth showing as they are, anything else gets a generic message (the details are in the log)
+func userErrorMessage(err error) string {
+	var unavailable *store.UnavailableError
+	switch {
+	case errors.Is(err, store.ErrUserExists), errors.Is(err, store.ErrUserNotFound), errors.Is(err, store.ErrUsersUnsupported):
+		return err.Error()
+	case errors.As(err, &unavailable):
+		return "The datastore is unavailable, try again later"
+	}
+	return "Something went wrong, try again"
+}
+
+// Back to the Users page with an "error" or "success" message
+func usersRedirect(c *fiber.Ctx, kind string, message string) error {
+	setFlash(c, kind, message)
+	return c.Redirect("/main/users")
+}
+
+func passwordRedirect(c *fiber.Ctx, kind string, message string) error {
+	setFlash(c, kind, message)
+	return c.Redirect("/main/account/password")
+}
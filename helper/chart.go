@@ -0,0 +1,286 @@
+package helper
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+func ParseBucket(bucket string) (string, error) {
+	switch bucket {
+	case "":
+		return BucketMonth, nil
+	case BucketDay, BucketWeek, BucketMonth:
+		return bucket, nil
+	default:
+		return "", errors.New("Unable to parse chart bucket")
+	}
+}
+
+// How many pineapples go into one unit of each product.
+// rough numbers until the purchases/inventory module tells us the real usage
+func ParseItemToFruitUsage(itemId int) (float64, error) {
+	switch {
+	case itemId == 1:
+		return 0.5, nil
+	case itemId == 2:
+		return 0.33, nil
+	case itemId == 3:
+		return 1, nil
+	default:
+		return 0, errors.New("Unable to parse item id")
+	}
+}
+
+// Start of the bucket t falls into. Weeks start on monday.
+func BucketStart(t time.Time, bucket string) time.Time {
+	y, m, d := t.Date()
+	switch bucket {
+	case BucketMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case BucketWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func BucketLabel(t time.Time, bucket string) string {
+	switch bucket {
+	case BucketMonth:
+		return t.Format("Jan 2006")
+	case BucketWeek:
+		return "Wk " + t.Format("02 Jan")
+	default:
+		return t.Format("02 Jan")
+	}
+}
+
+func nextBucket(t time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketMonth:
+		return t.AddDate(0, 1, 0)
+	case BucketWeek:
+		return t.AddDate(0, 0, 7)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// Most buckets a chart is drawn with, a year of days and ten years of weeks or months
+var MaxChartBuckets = map[string]int{BucketDay: 366, BucketWeek: 522, BucketMonth: 120}
+
+// Whether start to end fits in MaxChartBuckets of bucket, counted without building them
+func ChartRangeFits(start time.Time, end time.Time, bucket string) bool {
+	n := 0
+	for t := BucketStart(start, bucket); !t.After(end); t = nextBucket(t, bucket) {
+		if n++; n > MaxChartBuckets[bucket] {
+			return false
+		}
+	}
+	return true
+}
+
+// Every bucket between start and end, so days without sales still show up as 0 on the chart.
+func ChartBuckets(start time.Time, end time.Time, bucket string) []time.Time {
+	buckets := []time.Time{}
+	for t := BucketStart(start, bucket); !t.After(end); t = nextBucket(t, bucket) {
+		buckets = append(buckets, t)
+	}
+	return buckets
+}
+
+// Sums values(day) into series (one per key of the returned map) over the buckets between start and end
+func bucketSeries(days []*model.DailyRollup, start time.Time, end time.Time, bucket string, values func(day *model.DailyRollup) map[string]float64) *model.ChartData {
+	buckets := ChartBuckets(start, end, bucket)
+	index := map[time.Time]int{}
+	chart := &model.ChartData{Categories: []string{}, Series: []*model.ChartSeries{}}
+	for i, b := range buckets {
+		index[b] = i
+		chart.Categories = append(chart.Categories, BucketLabel(b, bucket))
+	}
+
+	series := map[string]*model.ChartSeries{}
+	for _, day := range days {
+		t, err := time.ParseInLocation("2006-01-02", day.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		pos, ok := index[BucketStart(t, bucket)]
+		if !ok {
+			continue
+		}
+		for name, value := range values(day) {
+			s, ok := series[name]
+			if !ok {
+				s = &model.ChartSeries{Name: name, Data: make([]float64, len(buckets))}
+				series[name] = s
+			}
+			s.Data[pos] += value
+		}
+	}
+
+	names := make([]string, 0, len(series))
+	for name := range series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		roundSeries(series[name])
+		chart.Series = append(chart.Series, series[name])
+	}
+
+	return chart
+}
+
+func roundSeries(s *model.ChartSeries) {
+	for i := range s.Data {
+		s.Data[i] = RoundTo(s.Data[i], 2)
+	}
+}
+
+func itemName(itemId int) string {
+	name, err := ParseItemToString(itemId)
+	if err != nil {
+		return "Unknown"
+	}
+	return name
+}
+
+// Sums value(item id, aggregate) per product name
+func perItem(items map[int]*model.SalesAggregate, value func(int, *model.SalesAggregate) float64) map[string]float64 {
+	values := map[string]float64{}
+	for id, a := range items {
+		values[itemName(id)] += value(id, a)
+	}
+	return values
+}
+
+// Income vs Expenses
+// expenses are 0 until the expenses module exists, same as the sales report
+func ChartIncomeExpenses(days []*model.DailyRollup, start time.Time, end time.Time, bucket string) *model.ChartData {
+	income := bucketSeries(days, start, end, bucket, func(day *model.DailyRollup) map[string]float64 {
+		return map[string]float64{"Income": day.Total.Amount}
+	})
+
+	chart := &model.ChartData{Categories: income.Categories}
+	chart.Series = []*model.ChartSeries{
+		seriesOrEmpty(income, "Income"),
+		{Name: "Expenses", Data: make([]float64, len(chart.Categories))},
+	}
+	return chart
+}
+
+// Net revenue vs Gross revenue vs Free cash flow
+// free cash flow is what we actually collected (everything but "Free") minus expenses
+func ChartRevenueCashFlow(days []*model.DailyRollup, start time.Time, end time.Time, bucket string) *model.ChartData {
+	income := ChartIncomeExpenses(days, start, end, bucket)
+	gross := income.Series[0]
+	expenses := income.Series[1]
+
+	collected := bucketSeries(days, start, end, bucket, func(day *model.DailyRollup) map[string]float64 {
+		amount := day.Total.Amount
+		if free, ok := day.Payments[99]; ok {
+			amount -= free.Amount
+		}
+		return map[string]float64{"Collected": amount}
+	})
+	collectedSeries := seriesOrEmpty(collected, "Collected")
+
+	net := &model.ChartSeries{Name: "Net Revenue", Data: make([]float64, len(gross.Data))}
+	fcf := &model.ChartSeries{Name: "Free Cash Flow", Data: make([]float64, len(gross.Data))}
+	for i := range gross.Data {
+		net.Data[i] = RoundTo(gross.Data[i]-expenses.Data[i], 2)
+		fcf.Data[i] = RoundTo(collectedSeries.Data[i]-expenses.Data[i], 2)
+	}
+
+	return &model.ChartData{
+		Categories: income.Categories,
+		Series: []*model.ChartSeries{
+			net,
+			{Name: "Gross Revenue", Data: gross.Data},
+			fcf,
+		},
+	}
+}
+
+// Units sold per product
+func ChartProductTrend(days []*model.DailyRollup, start time.Time, end time.Time, bucket string) *model.ChartData {
+	return bucketSeries(days, start, end, bucket, func(day *model.DailyRollup) map[string]float64 {
+		return perItem(day.Items, func(_ int, a *model.SalesAggregate) float64 {
+			return a.Qty
+		})
+	})
+}
+
+// Units sold per product by hour of operation (an operation's first sale of the day is its start)
+func ChartProductMovement(days []*model.DailyRollup) *model.ChartData {
+	merged := MergeRollups(days)
+
+	hours := 1
+	for _, op := range merged.Operations {
+		for _, itemHours := range op.Hours {
+			for hour := range itemHours {
+				if hour+1 > hours {
+					hours = hour + 1
+				}
+			}
+		}
+	}
+
+	chart := &model.ChartData{Categories: []string{}, Series: []*model.ChartSeries{}}
+	for hour := 0; hour < hours; hour++ {
+		chart.Categories = append(chart.Categories, operationHourLabel(hour))
+	}
+
+	series := map[string]*model.ChartSeries{}
+	names := []string{}
+	for _, op := range merged.Operations {
+		for itemId, itemHours := range op.Hours {
+			name := itemName(itemId)
+			s, ok := series[name]
+			if !ok {
+				s = &model.ChartSeries{Name: name, Data: make([]float64, hours)}
+				series[name] = s
+				names = append(names, name)
+			}
+			for hour, units := range itemHours {
+				s.Data[hour] += units
+			}
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		roundSeries(series[name])
+		chart.Series = append(chart.Series, series[name])
+	}
+	return chart
+}
+
+// Pineapples used, per product
+func ChartFruitConsumption(days []*model.DailyRollup, start time.Time, end time.Time, bucket string) *model.ChartData {
+	return bucketSeries(days, start, end, bucket, func(day *model.DailyRollup) map[string]float64 {
+		return perItem(day.Items, func(itemId int, a *model.SalesAggregate) float64 {
+			usage, _ := ParseItemToFruitUsage(itemId)
+			return a.Qty * usage
+		})
+	})
+}
+
+func seriesOrEmpty(chart *model.ChartData, name string) *model.ChartSeries {
+	if len(chart.Series) > 0 {
+		chart.Series[0].Name = name
+		return chart.Series[0]
+	}
+	return &model.ChartSeries{Name: name, Data: make([]float64, len(chart.Categories))}
+}
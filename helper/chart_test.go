@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChartRangeFits(t *testing.T) {
+	day := func(s string) time.Time {
+		d, err := time.ParseInLocation("2006-01-02", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tests := []struct {
+		start, end string
+		bucket     string
+		want       bool
+	}{
+		{"2026-01-01", "2026-01-01", BucketDay, true},
+		// a leap year of days
+		{"2024-01-01", "2024-12-31", BucketDay, true},
+		{"2024-01-01", "2025-01-01", BucketDay, false},
+		{"2016-01-01", "2025-12-31", BucketMonth, true},
+		{"2016-01-01", "2026-01-01", BucketMonth, false},
+		{"2016-01-04", "2025-12-28", BucketWeek, true},
+		{"1000-01-01", "9999-12-31", BucketWeek, false},
+		{"1000-01-01", "9999-12-31", BucketMonth, false},
+	}
+	for _, tt := range tests {
+		if got := ChartRangeFits(day(tt.start), day(tt.end), tt.bucket); got != tt.want {
+			t.Errorf("ChartRangeFits(%s, %s, %s) = %v, want %v", tt.start, tt.end, tt.bucket, got, tt.want)
+		}
+	}
+}
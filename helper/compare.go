@@ -0,0 +1,83 @@
+package helper
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// The range of the same length right before start..end (both inclusive dates)
+func PreviousPeriod(start time.Time, end time.Time) (time.Time, time.Time) {
+	days := int(end.Sub(start).Hours()/24) + 1
+	return start.AddDate(0, 0, -days), start.AddDate(0, 0, -1)
+}
+
+// The same range one year back
+func SamePeriodLastYear(start time.Time, end time.Time) (time.Time, time.Time) {
+	return start.AddDate(-1, 0, 0), end.AddDate(-1, 0, 0)
+}
+
+func ComparePeriods(label string, start time.Time, end time.Time, current *model.DailyRollup, previous *model.DailyRollup) *model.ViewPeriodComparison {
+	comparison := &model.ViewPeriodComparison{
+		Label:     label,
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Report:    []*model.ViewReportDelta{},
+		Products:  []*model.ViewReportDelta{},
+	}
+
+	cur := CalculateSalesReport(current)
+	prev := CalculateSalesReport(previous)
+	fields := []struct {
+		name string
+		cur  float64
+		prev float64
+	}{
+		{"Total Gross Revenue", cur.TotalGrossRevenue, prev.TotalGrossRevenue},
+		{"Total Expenses", cur.TotalExpenses, prev.TotalExpenses},
+		{"Total Net Revenue", cur.TotalNetRevenue, prev.TotalNetRevenue},
+		{"Income Tax", cur.IncomeTax, prev.IncomeTax},
+		{"Grant/Loan", cur.GrantLoan, prev.GrantLoan},
+		{"Profit/Loss", cur.ProfitLoss, prev.ProfitLoss},
+	}
+	for _, f := range fields {
+		comparison.Report = append(comparison.Report, NewReportDelta(f.name, f.cur, f.prev))
+	}
+
+	// every product sold in either period
+	units := map[int][2]float64{}
+	names := map[int]string{}
+	for _, row := range CalculateItemBreakdown(current) {
+		u := units[row.ID]
+		u[0] = row.Qty
+		units[row.ID] = u
+		names[row.ID] = row.Name
+	}
+	for _, row := range CalculateItemBreakdown(previous) {
+		u := units[row.ID]
+		u[1] = row.Qty
+		units[row.ID] = u
+		names[row.ID] = row.Name
+	}
+	ids := make([]int, 0, len(units))
+	for id := range units {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		comparison.Products = append(comparison.Products, NewReportDelta(names[id], units[id][0], units[id][1]))
+	}
+
+	return comparison
+}
+
+func NewReportDelta(name string, current float64, previous float64) *model.ViewReportDelta {
+	return &model.ViewReportDelta{
+		Name:          name,
+		Current:       RoundTo(current, 2),
+		Previous:      RoundTo(previous, 2),
+		Change:        RoundTo(current-previous, 2),
+		ChangePercent: PercentChange(current, previous),
+	}
+}
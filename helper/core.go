@@ -1,15 +1,12 @@
 package helper
 
 import (
-	"encoding/json"
 	"errors"
-	"io"
-	"log"
-	"net/http"
-	"time"
 
-	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
 	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/CRTOsp3ck/mims-app/store"
 	"github.com/gofiber/fiber/v2"
 )
 
@@ -72,55 +69,44 @@ func ParseItemToString(itemId int) (string, error) {
 	}
 }
 
-func CheckAuthState(c *fiber.Ctx) bool {
-	// send request to /auth
-	url := config.Config("API_SERVER_ADDR") + "/auth/sta"
-
-	client := http.Client{
-		Timeout: time.Second * 2, // Timeout after 2 seconds
+func ParseItemToListPrice(itemId int) (float64, error) {
+	switch {
+	case itemId == 1:
+		return 8.00, nil
+	case itemId == 2:
+		return 8.00, nil
+	case itemId == 3:
+		return 5.00, nil
+	default:
+		return 0, errors.New("Unable to parse item id")
 	}
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+// Logged in when the store takes the token and the app has a session for it
+func CheckAuthState(c *fiber.Ctx) bool {
+	ok, err := store.Current().CheckAuth(c.UserContext(), c.Cookies("token"))
 	if err != nil {
-		log.Println("Post request not completed -", err)
+		logger.FromContext(c.UserContext()).Error("Error checking auth state", "err", err)
 		return false
 	}
+	return ok && CurrentSession(c) != nil
+}
 
-	// create a Bearer string by appending string access token
-	bearer := "Bearer " + c.Cookies("token")
-	// add authorization header to the req
-	req.Header.Add("Authorization", bearer)
-	req.Header.Set("User-Agent", "mims-app")
+// Locals key the session is kept under for the rest of the request
+const sessionLocal = "session"
 
-	res, err := client.Do(req)
-	if err != nil {
-		log.Println("Error occured while awaiting response -", err)
-		return false
+// The caller's session (identity and role), nil when there is none
+func CurrentSession(c *fiber.Ctx) *model.Session {
+	if sess, ok := c.Locals(sessionLocal).(*model.Session); ok {
+		return sess
 	}
-
-	if res.Body != nil {
-		defer res.Body.Close()
-	}
-
-	body, err := io.ReadAll(res.Body)
+	sess, err := session.Current().Get(c.Cookies("token"))
 	if err != nil {
-		log.Println("Error reading response body -", err)
-		return false
+		logger.FromContext(c.UserContext()).Error("Error reading session", "err", err)
+		return nil
 	}
-
-	var respBody model.ResponseBody
-
-	if err := json.Unmarshal(body, &respBody); err != nil {
-		log.Println("Error unmarshalling response body -", err)
-		return false
+	if sess != nil {
+		c.Locals(sessionLocal, sess)
 	}
-
-	if respBody.Message == "Invalid or expired JWT" {
-		return false
-	} else if respBody.Message == "authenticated" {
-		return true
-	}
-
-	// hmm?
-	return false
+	return sess
 }
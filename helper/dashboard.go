@@ -0,0 +1,120 @@
+package helper
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// An operation with no sale for this long is shown as idle instead of open
+const operationIdleAfter = time.Hour
+
+// Earliest date the dashboard needs sales from (start of last week or start of the month, whichever comes first)
+func DashboardStartDate(now time.Time) time.Time {
+	lastWeek := BucketStart(now, BucketWeek).AddDate(0, 0, -7)
+	month := BucketStart(now, BucketMonth)
+	if lastWeek.Before(month) {
+		return lastWeek
+	}
+	return month
+}
+
+// reports is whether the viewer may see profit and product revenue (PermReports), MonthToDate and TopProducts stay empty otherwise
+func CalculateDashboard(days []*model.DailyRollup, now time.Time, reports bool) model.ViewDashboard {
+	dashboard := model.ViewDashboard{Greeting: Greeting(now)}
+
+	today := now.Format("2006-01-02")
+	thisWeek := BucketStart(now, BucketWeek).Format("2006-01-02")
+	lastWeek := BucketStart(now, BucketWeek).AddDate(0, 0, -7).Format("2006-01-02")
+	lastWeekToday := now.AddDate(0, 0, -7).Format("2006-01-02")
+	month := BucketStart(now, BucketMonth).Format("2006-01-02")
+
+	todayRollup := NewDailyRollup(today)
+	monthDays := []*model.DailyRollup{}
+	for _, day := range days {
+		if day.Date == today {
+			todayRollup = day
+		}
+		if day.Date >= thisWeek && day.Date <= today {
+			dashboard.ThisWeekRevenue += day.Total.Amount
+		} else if day.Date >= lastWeek && day.Date < lastWeekToday {
+			dashboard.LastWeekRevenue += day.Total.Amount
+		} else if day.Date == lastWeekToday {
+			// same point in time last week, to the hour
+			for hour, amount := range day.Hours {
+				if hour <= now.Hour() {
+					dashboard.LastWeekRevenue += amount
+				}
+			}
+		}
+		if day.Date >= month && day.Date <= today {
+			monthDays = append(monthDays, day)
+		}
+	}
+
+	dashboard.TodaySales = todayRollup.Total.Count
+	dashboard.TodayRevenue = RoundTo(todayRollup.Total.Amount, 2)
+	dashboard.TodayUnits = RoundTo(todayRollup.Total.Qty, 2)
+	dashboard.ThisWeekRevenue = RoundTo(dashboard.ThisWeekRevenue, 2)
+	dashboard.LastWeekRevenue = RoundTo(dashboard.LastWeekRevenue, 2)
+	dashboard.WeekChange = PercentChange(dashboard.ThisWeekRevenue, dashboard.LastWeekRevenue)
+	if reports {
+		monthToDate := MergeRollups(monthDays)
+		dashboard.MonthToDate = CalculateSalesReport(monthToDate)
+		dashboard.TopProducts = CalculateItemBreakdown(monthToDate)
+	}
+	dashboard.OperationsStatus = CalculateOperationsStatus(todayRollup, now)
+
+	return dashboard
+}
+
+// Percentage change from previous to current. 0 when there is nothing to compare against.
+func PercentChange(current float64, previous float64) float64 {
+	if previous == 0 {
+		return 0
+	}
+	return RoundTo((current-previous)/math.Abs(previous)*100, 2)
+}
+
+func Greeting(now time.Time) string {
+	switch h := now.Hour(); {
+	case h < 12:
+		return "Good Morning"
+	case h < 18:
+		return "Good Afternoon"
+	default:
+		return "Good Evening"
+	}
+}
+
+// Status of every operation that has sold something today
+func CalculateOperationsStatus(today *model.DailyRollup, now time.Time) []*model.ViewOperationStatus {
+	ids := make([]int, 0, len(today.Operations))
+	for id := range today.Operations {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	statuses := []*model.ViewOperationStatus{}
+	for _, id := range ids {
+		op := today.Operations[id]
+		name, err := ParseOperationToString(id)
+		if err != nil {
+			name = "Unknown"
+		}
+		status := "Open"
+		if now.Sub(op.LastSale) > operationIdleAfter {
+			status = "Idle"
+		}
+		statuses = append(statuses, &model.ViewOperationStatus{
+			Name:     name,
+			Status:   status,
+			OpenedAt: op.FirstSale.In(now.Location()).Format("15:04"),
+			LastSale: op.LastSale.In(now.Location()).Format("15:04"),
+			Revenue:  RoundTo(op.Amount, 2),
+		})
+	}
+	return statuses
+}
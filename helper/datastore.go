@@ -0,0 +1,13 @@
+package helper
+
+import (
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Fetches sales from the store using the caller's token.
+// start and end are YYYY-MM-DD, leave both empty for every sale ever made.
+func FetchSales(c *fiber.Ctx, start string, end string) ([]model.JsonSale, error) {
+	return store.Current().FindSales(c.UserContext(), c.Cookies("token"), start, end)
+}
@@ -0,0 +1,82 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// Monday first, thats how we plan the week
+var weekdayOrder = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+func operationHourLabel(hour int) string {
+	return fmt.Sprintf("Hour %d", hour+1)
+}
+
+// Units sold per product, bucketed by hour of operation (x) and weekday (series).
+// We dont record when an operation opens, so the first sale of an operation on a given day is taken as its start time.
+func CalculateHourlyHeatmaps(days []*model.DailyRollup) []*model.ViewHeatmap {
+	hours := 1
+	for _, day := range days {
+		for _, op := range day.Operations {
+			for _, itemHours := range op.Hours {
+				for hour := range itemHours {
+					if hour+1 > hours {
+						hours = hour + 1
+					}
+				}
+			}
+		}
+	}
+
+	heatmaps := map[int]*model.ViewHeatmap{}
+	cells := map[int]map[time.Weekday][]float64{}
+	for _, day := range days {
+		t, err := time.ParseInLocation("2006-01-02", day.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		wd := t.Weekday()
+		for _, op := range day.Operations {
+			for itemId, itemHours := range op.Hours {
+				if _, ok := heatmaps[itemId]; !ok {
+					heatmaps[itemId] = &model.ViewHeatmap{ItemID: itemId, Product: itemName(itemId)}
+					cells[itemId] = map[time.Weekday][]float64{}
+					for _, w := range weekdayOrder {
+						cells[itemId][w] = make([]float64, hours)
+					}
+				}
+				for hour, units := range itemHours {
+					cells[itemId][wd][hour] += units
+				}
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(heatmaps))
+	for id := range heatmaps {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	result := []*model.ViewHeatmap{}
+	for _, id := range ids {
+		heatmap := heatmaps[id]
+		// apex draws the first series at the bottom, so sunday goes first to get monday on top
+		for i := len(weekdayOrder) - 1; i >= 0; i-- {
+			wd := weekdayOrder[i]
+			series := &model.HeatmapSeries{Name: wd.String()[:3], Data: []*model.HeatmapCell{}}
+			for hour, units := range cells[id][wd] {
+				series.Data = append(series.Data, &model.HeatmapCell{X: operationHourLabel(hour), Y: RoundTo(units, 2)})
+			}
+			heatmap.Series = append(heatmap.Series, series)
+		}
+		result = append(result, heatmap)
+	}
+
+	return result
+}
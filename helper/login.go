@@ -0,0 +1,62 @@
+package helper
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// How far back LoginBlocked needs an identity's attempts
+func LoginLookback(limits config.Login) time.Duration {
+	if limits.IdentityWindow > limits.Lockout {
+		return limits.IdentityWindow
+	}
+	return limits.Lockout
+}
+
+// Whether an identity with these attempts (oldest first, at least LoginLookback of them) may try again now.
+// Returns model.LoginLocked after LockoutFailures wrong passwords in a row, model.LoginRateLimited after
+// IdentityLimit tries within IdentityWindow, and when that's over. Turned away tries don't count.
+func LoginBlocked(attempts []*model.LoginAttempt, limits config.Login, now time.Time) (string, time.Time) {
+	failures := 0
+	var lastFailure time.Time
+	for i := len(attempts) - 1; i >= 0; i-- {
+		a := attempts[i]
+		if a.Result == model.LoginSuccess || now.Sub(a.Time) > limits.Lockout {
+			break
+		}
+		if a.Result == model.LoginInvalidCredentials {
+			if failures == 0 {
+				lastFailure = a.Time
+			}
+			failures++
+		}
+	}
+	if failures >= limits.LockoutFailures {
+		return model.LoginLocked, lastFailure.Add(limits.Lockout)
+	}
+
+	tries := []*model.LoginAttempt{}
+	for _, a := range attempts {
+		if now.Sub(a.Time) <= limits.IdentityWindow && a.Result != model.LoginLocked && a.Result != model.LoginRateLimited {
+			tries = append(tries, a)
+		}
+	}
+	if len(tries) >= limits.IdentityLimit {
+		// free again when the oldest try in the window falls out of it
+		return model.LoginRateLimited, tries[len(tries)-limits.IdentityLimit].Time.Add(limits.IdentityWindow)
+	}
+	return "", time.Time{}
+}
+
+// "5 minutes", rounded up so it's never "0 minutes"
+func MinutesUntil(t time.Time, now time.Time) string {
+	minutes := int(math.Ceil(t.Sub(now).Minutes()))
+	if minutes <= 1 {
+		return "a minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
@@ -0,0 +1,78 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func TestLoginBlocked(t *testing.T) {
+	limits := config.Login{
+		IdentityLimit:   4,
+		IdentityWindow:  10 * time.Minute,
+		LockoutFailures: 3,
+		Lockout:         15 * time.Minute,
+	}
+	now := time.Date(2026, 3, 14, 12, 0, 0, 0, time.Local)
+	ago := func(minutes int) time.Time { return now.Add(-time.Duration(minutes) * time.Minute) }
+	// results and how many minutes ago, oldest first
+	attempts := func(tries ...interface{}) []*model.LoginAttempt {
+		list := []*model.LoginAttempt{}
+		for i := 0; i < len(tries); i += 2 {
+			list = append(list, &model.LoginAttempt{Result: tries[i].(string), Time: ago(tries[i+1].(int))})
+		}
+		return list
+	}
+	const (
+		wrong   = model.LoginInvalidCredentials
+		success = model.LoginSuccess
+	)
+
+	tests := []struct {
+		name      string
+		attempts  []*model.LoginAttempt
+		want      string
+		wantUntil time.Time
+	}{
+		{"no attempts", attempts(), "", time.Time{}},
+		{"fewer wrong passwords than the lockout", attempts(wrong, 5, wrong, 4), "", time.Time{}},
+		{"wrong passwords in a row", attempts(wrong, 5, wrong, 4, wrong, 3), model.LoginLocked, ago(3).Add(limits.Lockout)},
+		{"a success starts the count again", attempts(wrong, 14, wrong, 13, success, 12, wrong, 3), "", time.Time{}},
+		{"lockout over", attempts(wrong, 20, wrong, 19, wrong, 18), "", time.Time{}},
+		{"only wrong passwords within the lockout count", attempts(wrong, 20, wrong, 4, wrong, 3), "", time.Time{}},
+		{"other failures don't lock", attempts(model.LoginUnavailable, 14, model.LoginError, 13, model.LoginDisabled, 12), "", time.Time{}},
+		{"too many tries", attempts(success, 8, success, 6, model.LoginUnavailable, 4, success, 2), model.LoginRateLimited, ago(8).Add(limits.IdentityWindow)},
+		{"tries older than the window", attempts(success, 12, success, 6, success, 4, success, 2), "", time.Time{}},
+		{"turned away tries don't count", attempts(success, 8, model.LoginRateLimited, 6, model.LoginLocked, 4, success, 2), "", time.Time{}},
+		{"lockout before the limit", attempts(wrong, 4, wrong, 3, wrong, 2, wrong, 1), model.LoginLocked, ago(1).Add(limits.Lockout)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, until := LoginBlocked(tt.attempts, limits, now)
+			if got != tt.want || !until.Equal(tt.wantUntil) {
+				t.Errorf("LoginBlocked = %q until %v, want %q until %v", got, until, tt.want, tt.wantUntil)
+			}
+		})
+	}
+}
+
+func TestMinutesUntil(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{0, "a minute"},
+		{30 * time.Second, "a minute"},
+		{61 * time.Second, "2 minutes"},
+		{15 * time.Minute, "15 minutes"},
+	}
+	for _, tt := range tests {
+		if got := MinutesUntil(now.Add(tt.in), now); got != tt.want {
+			t.Errorf("MinutesUntil(%s) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package helper
+
+import "github.com/CRTOsp3ck/mims-app/model"
+
+// Permissions checked by the routes (handler.Require) and the sidebar (.Can in layouts/main)
+const (
+	PermDashboard   = "dashboard"
+	PermRecordSales = "record_sales"
+	// today's sales only without PermSalesHistoryAll
+	PermSalesHistory    = "sales_history"
+	PermSalesHistoryAll = "sales_history_all"
+	// sales report, charts, heatmap and forecast, the profit figures live here
+	PermReports       = "reports"
+	PermTargets       = "targets"
+	PermManageTargets = "manage_targets"
+	PermPurchases     = "purchases"
+	PermManageUsers   = "manage_users"
+	// settling queued sales that may already be in the datastore
+	PermReviewSync = "review_sync"
+)
+
+var rolePermissions = map[string][]string{
+	model.RoleOwner: {
+		PermDashboard, PermRecordSales, PermSalesHistory, PermSalesHistoryAll, PermReports,
+		PermTargets, PermManageTargets, PermPurchases, PermManageUsers, PermReviewSync,
+	},
+	model.RoleCashier: {
+		PermDashboard, PermRecordSales, PermSalesHistory,
+	},
+	model.RoleAccountant: {
+		PermDashboard, PermSalesHistory, PermSalesHistoryAll, PermReports, PermTargets, PermPurchases, PermReviewSync,
+	},
+	model.RoleViewer: {
+		PermDashboard, PermSalesHistory, PermSalesHistoryAll, PermTargets,
+	},
+}
+
+var Roles = []string{model.RoleOwner, model.RoleCashier, model.RoleAccountant, model.RoleViewer}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func Can(role string, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Every permission with whether role has it, for the templates
+func Permissions(role string) map[string]bool {
+	perms := map[string]bool{}
+	for _, p := range rolePermissions[model.RoleOwner] {
+		perms[p] = Can(role, p)
+	}
+	return perms
+}
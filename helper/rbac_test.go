@@ -0,0 +1,75 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func TestCan(t *testing.T) {
+	all := []string{
+		PermDashboard, PermRecordSales, PermSalesHistory, PermSalesHistoryAll, PermReports,
+		PermTargets, PermManageTargets, PermPurchases, PermManageUsers, PermReviewSync,
+	}
+	tests := []struct {
+		role string
+		// every other permission is expected to be refused
+		allowed []string
+	}{
+		{model.RoleOwner, all},
+		{model.RoleCashier, []string{PermDashboard, PermRecordSales, PermSalesHistory}},
+		{model.RoleAccountant, []string{PermDashboard, PermSalesHistory, PermSalesHistoryAll, PermReports, PermTargets, PermPurchases, PermReviewSync}},
+		{model.RoleViewer, []string{PermDashboard, PermSalesHistory, PermSalesHistoryAll, PermTargets}},
+		// unknown or missing roles get nothing
+		{"admin", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		allowed := map[string]bool{}
+		for _, p := range tt.allowed {
+			allowed[p] = true
+		}
+		for _, p := range all {
+			if got := Can(tt.role, p); got != allowed[p] {
+				t.Errorf("Can(%q, %q) = %v, want %v", tt.role, p, got, allowed[p])
+			}
+		}
+		if got := Permissions(tt.role); len(got) != len(all) {
+			t.Errorf("Permissions(%q) has %d permissions, want %d", tt.role, len(got), len(all))
+		}
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range Roles {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+	for _, role := range []string{"", "admin", "Owner"} {
+		if ValidRole(role) {
+			t.Errorf("ValidRole(%q) = true", role)
+		}
+	}
+}
+
+// Profit and product revenue are left out for roles without PermReports
+func TestCalculateDashboardReports(t *testing.T) {
+	now := time.Now()
+	day := NewDailyRollup(now.Format("2006-01-02"))
+	AddSaleToRollup(day, model.JsonSale{Amount: 8, Qty: 1, PaymentType: 1, OperationID: 1, ItemID: 1, CreatedAt: now})
+	days := []*model.DailyRollup{day}
+
+	for _, role := range Roles {
+		reports := Can(role, PermReports)
+		dashboard := CalculateDashboard(days, now, reports)
+		if dashboard.TodayRevenue != 8 {
+			t.Errorf("%s: TodayRevenue = %v, want 8", role, dashboard.TodayRevenue)
+		}
+		if hidden := dashboard.MonthToDate == (model.ViewSalesReport{}) && len(dashboard.TopProducts) == 0; hidden == reports {
+			t.Errorf("%s: month to date and top products shown = %v, want %v", role, !hidden, reports)
+		}
+	}
+}
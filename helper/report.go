@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"sort"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// Payment types in the order they should be listed in the report
+var paymentTypeOrder = []int{1, 2, 3, 99}
+
+func CalculateSalesReport(r *model.DailyRollup) model.ViewSalesReport {
+	vsr := model.ViewSalesReport{}
+
+	vsr.TotalGrossRevenue = RoundTo(r.Total.Amount, 2)
+	vsr.TotalExpenses = RoundTo(0.00, 2)
+	vsr.TotalNetRevenue = RoundTo(vsr.TotalGrossRevenue-vsr.TotalExpenses, 2)
+	vsr.IncomeTax = RoundTo(vsr.TotalNetRevenue*0.12, 2)
+	vsr.GrantLoan = RoundTo(0.00, 2)
+	vsr.ProfitLoss = RoundTo(vsr.TotalGrossRevenue+vsr.GrantLoan-vsr.TotalExpenses-vsr.IncomeTax, 2)
+
+	return vsr
+}
+
+// Breaks the sales down by payment type (cash/QR/free movements).
+// Every known payment type gets a row even when there were no sales, so the table doesnt jump around.
+func CalculatePaymentBreakdown(r *model.DailyRollup) []*model.ViewPaymentBreakdown {
+	keys := append([]int{}, paymentTypeOrder...)
+	for pt := range r.Payments {
+		if _, err := ParsePaymentMethodToString(pt); err != nil {
+			//payment type we dont know about yet, still show it so the totals add up
+			keys = append(keys, pt)
+		}
+	}
+	sort.Ints(keys)
+
+	breakdown := []*model.ViewPaymentBreakdown{}
+	for _, pt := range keys {
+		name, err := ParsePaymentMethodToString(pt)
+		if err != nil {
+			name = "Unknown"
+		}
+		row := &model.ViewPaymentBreakdown{PaymentType: name}
+		if a, ok := r.Payments[pt]; ok {
+			row.Count = a.Count
+			row.Qty = RoundTo(a.Qty, 2)
+			row.Amount = RoundTo(a.Amount, 2)
+			row.ListValue = RoundTo(a.ListValue, 2)
+			if r.Total.Amount > 0 {
+				row.Share = RoundTo(a.Amount/r.Total.Amount*100, 2)
+			}
+		}
+		breakdown = append(breakdown, row)
+	}
+
+	return breakdown
+}
+
+func CalculateOperationBreakdown(r *model.DailyRollup) []*model.ViewRevenueBreakdown {
+	aggregates := map[int]*model.SalesAggregate{}
+	for id, op := range r.Operations {
+		aggregates[id] = &op.SalesAggregate
+	}
+	return calculateRevenueBreakdown(aggregates, r.Total.Amount, ParseOperationToString)
+}
+
+func CalculateItemBreakdown(r *model.DailyRollup) []*model.ViewRevenueBreakdown {
+	return calculateRevenueBreakdown(r.Items, r.Total.Amount, ParseItemToString)
+}
+
+// One row per id (operation, item...), sorted by amount, best earner first.
+func calculateRevenueBreakdown(aggregates map[int]*model.SalesAggregate, total float64, name func(int) (string, error)) []*model.ViewRevenueBreakdown {
+	breakdown := []*model.ViewRevenueBreakdown{}
+	for id, a := range aggregates {
+		rowName, err := name(id)
+		if err != nil {
+			rowName = "Unknown"
+		}
+		row := &model.ViewRevenueBreakdown{
+			ID:     id,
+			Name:   rowName,
+			Count:  a.Count,
+			Qty:    RoundTo(a.Qty, 2),
+			Amount: RoundTo(a.Amount, 2),
+		}
+		if a.Count > 0 {
+			row.AverageTicket = RoundTo(a.Amount/float64(a.Count), 2)
+		}
+		if total > 0 {
+			row.Share = RoundTo(a.Amount/total*100, 2)
+		}
+		breakdown = append(breakdown, row)
+	}
+
+	sort.Slice(breakdown, func(i, j int) bool {
+		if breakdown[i].Amount == breakdown[j].Amount {
+			return breakdown[i].ID < breakdown[j].ID
+		}
+		return breakdown[i].Amount > breakdown[j].Amount
+	})
+
+	return breakdown
+}
+
+// Drops the sales that dont match the operation/item in the filter. Zero means dont filter on it.
+func FilterSales(sales []model.JsonSale, filter model.SalesHistoryFilter) []model.JsonSale {
+	filtered := []model.JsonSale{}
+	for index := range sales {
+		if filter.OperationID != 0 && sales[index].OperationID != filter.OperationID {
+			continue
+		}
+		if filter.ItemID != 0 && sales[index].ItemID != filter.ItemID {
+			continue
+		}
+		filtered = append(filtered, sales[index])
+	}
+	return filtered
+}
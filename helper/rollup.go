@@ -0,0 +1,234 @@
+package helper
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func NewDailyRollup(date string) *model.DailyRollup {
+	return &model.DailyRollup{
+		Date:       date,
+		Hours:      map[int]float64{},
+		Items:      map[int]*model.SalesAggregate{},
+		Payments:   map[int]*model.SalesAggregate{},
+		Operations: map[int]*model.OperationRollup{},
+	}
+}
+
+// Rolls sales up into one DailyRollup per day (local time), keyed by date
+func RollupSales(sales []model.JsonSale) map[string]*model.DailyRollup {
+	// oldest first, the first sale of an operation on a day is its start time
+	sorted := make([]model.JsonSale, len(sales))
+	copy(sorted, sales)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	days := map[string]*model.DailyRollup{}
+	for index := range sorted {
+		date := sorted[index].CreatedAt.In(time.Local).Format("2006-01-02")
+		day, ok := days[date]
+		if !ok {
+			day = NewDailyRollup(date)
+			days[date] = day
+		}
+		AddSaleToRollup(day, sorted[index])
+	}
+	return days
+}
+
+// Adds one sale to the rollup of the day it was made.
+// Sales are expected in time order, an operation's first sale is taken as the time it opened.
+func AddSaleToRollup(day *model.DailyRollup, sale model.JsonSale) {
+	t := sale.CreatedAt.In(time.Local)
+	listPrice, _ := ParseItemToListPrice(sale.ItemID)
+
+	addToAggregate(&day.Total, sale, listPrice)
+	addToAggregate(aggregateFor(day.Items, sale.ItemID), sale, listPrice)
+	addToAggregate(aggregateFor(day.Payments, sale.PaymentType), sale, listPrice)
+	day.Hours[t.Hour()] += float64(sale.Amount)
+
+	op, ok := day.Operations[sale.OperationID]
+	if !ok {
+		op = &model.OperationRollup{
+			FirstSale: t,
+			LastSale:  t,
+			Items:     map[int]*model.SalesAggregate{},
+			Hours:     map[int]map[int]float64{},
+		}
+		day.Operations[sale.OperationID] = op
+	}
+	if t.After(op.LastSale) {
+		op.LastSale = t
+	}
+	addToAggregate(&op.SalesAggregate, sale, listPrice)
+	addToAggregate(aggregateFor(op.Items, sale.ItemID), sale, listPrice)
+
+	// 0 for the first hour the operation was open, 1 for the second hour and so on
+	hour := int(t.Sub(op.FirstSale).Hours())
+	if hour < 0 {
+		hour = 0
+	}
+	if op.Hours[sale.ItemID] == nil {
+		op.Hours[sale.ItemID] = map[int]float64{}
+	}
+	op.Hours[sale.ItemID][hour] += float64(sale.Qty)
+}
+
+// Adds the days up into one rollup for the whole range (Date is left empty).
+// Operations keep the earliest first sale and latest last sale, hours of operation are summed.
+func MergeRollups(days []*model.DailyRollup) *model.DailyRollup {
+	merged := NewDailyRollup("")
+	for _, day := range days {
+		MergeRollup(merged, day)
+	}
+	return merged
+}
+
+// Adds day into merged, see MergeRollups
+func MergeRollup(merged *model.DailyRollup, day *model.DailyRollup) {
+	mergeAggregate(&merged.Total, &day.Total)
+	for hour, amount := range day.Hours {
+		merged.Hours[hour] += amount
+	}
+	mergeAggregates(merged.Items, day.Items)
+	mergeAggregates(merged.Payments, day.Payments)
+
+	for id, op := range day.Operations {
+		m, ok := merged.Operations[id]
+		if !ok {
+			m = &model.OperationRollup{
+				FirstSale: op.FirstSale,
+				LastSale:  op.LastSale,
+				Items:     map[int]*model.SalesAggregate{},
+				Hours:     map[int]map[int]float64{},
+			}
+			merged.Operations[id] = m
+		}
+		if op.FirstSale.Before(m.FirstSale) {
+			m.FirstSale = op.FirstSale
+		}
+		if op.LastSale.After(m.LastSale) {
+			m.LastSale = op.LastSale
+		}
+		mergeAggregate(&m.SalesAggregate, &op.SalesAggregate)
+		mergeAggregates(m.Items, op.Items)
+		for itemId, hours := range op.Hours {
+			if m.Hours[itemId] == nil {
+				m.Hours[itemId] = map[int]float64{}
+			}
+			for hour, units := range hours {
+				m.Hours[itemId][hour] += units
+			}
+		}
+	}
+}
+
+// Takes day back out of merged, for a day that is about to be replaced. What's left with no sales is dropped.
+// First and last sale times of the operations stay as they were, there's nothing to narrow them down from.
+func SubtractRollup(merged *model.DailyRollup, day *model.DailyRollup) {
+	subtractAggregate(&merged.Total, &day.Total)
+	for hour, amount := range day.Hours {
+		merged.Hours[hour] -= amount
+	}
+	subtractAggregates(merged.Items, day.Items)
+	subtractAggregates(merged.Payments, day.Payments)
+
+	for id, op := range day.Operations {
+		m, ok := merged.Operations[id]
+		if !ok {
+			continue
+		}
+		subtractAggregate(&m.SalesAggregate, &op.SalesAggregate)
+		subtractAggregates(m.Items, op.Items)
+		for itemId, hours := range op.Hours {
+			for hour, units := range hours {
+				if m.Hours[itemId] != nil {
+					m.Hours[itemId][hour] -= units
+				}
+			}
+		}
+		if m.Count <= 0 {
+			delete(merged.Operations, id)
+		}
+	}
+}
+
+// The days between start and end (YYYY-MM-DD, both included)
+func RollupsBetween(days []*model.DailyRollup, start string, end string) []*model.DailyRollup {
+	between := []*model.DailyRollup{}
+	for _, day := range days {
+		if day.Date >= start && day.Date <= end {
+			between = append(between, day)
+		}
+	}
+	return between
+}
+
+// First and last day with sales, used when no range is given
+func RollupDateRange(days []*model.DailyRollup) (time.Time, time.Time) {
+	now := time.Now()
+	start, end := now, now
+	for i, day := range days {
+		t, err := time.ParseInLocation("2006-01-02", day.Date, time.Local)
+		if err != nil {
+			continue
+		}
+		if i == 0 || t.Before(start) {
+			start = t
+		}
+		if i == 0 || t.After(end) {
+			end = t
+		}
+	}
+	return start, end
+}
+
+func addToAggregate(a *model.SalesAggregate, sale model.JsonSale, listPrice float64) {
+	a.Count++
+	a.Qty += float64(sale.Qty)
+	a.Amount += float64(sale.Amount)
+	a.ListValue += float64(sale.Qty) * listPrice
+}
+
+func mergeAggregate(a *model.SalesAggregate, b *model.SalesAggregate) {
+	a.Count += b.Count
+	a.Qty += b.Qty
+	a.Amount += b.Amount
+	a.ListValue += b.ListValue
+}
+
+func subtractAggregate(a *model.SalesAggregate, b *model.SalesAggregate) {
+	a.Count -= b.Count
+	a.Qty -= b.Qty
+	a.Amount -= b.Amount
+	a.ListValue -= b.ListValue
+}
+
+func subtractAggregates(from map[int]*model.SalesAggregate, day map[int]*model.SalesAggregate) {
+	for id, a := range day {
+		if b, ok := from[id]; ok {
+			subtractAggregate(b, a)
+			if b.Count <= 0 {
+				delete(from, id)
+			}
+		}
+	}
+}
+
+func mergeAggregates(into map[int]*model.SalesAggregate, from map[int]*model.SalesAggregate) {
+	for id, a := range from {
+		mergeAggregate(aggregateFor(into, id), a)
+	}
+}
+
+func aggregateFor(aggregates map[int]*model.SalesAggregate, id int) *model.SalesAggregate {
+	a, ok := aggregates[id]
+	if !ok {
+		a = &model.SalesAggregate{}
+		aggregates[id] = a
+	}
+	return a
+}
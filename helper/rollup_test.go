@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func TestSubtractRollup(t *testing.T) {
+	at := time.Date(2024, 3, 4, 10, 0, 0, 0, time.Local)
+	sale := func(op int, item int, payment int, amount float32) model.JsonSale {
+		return model.JsonSale{Amount: amount, Qty: 1, PaymentType: payment, OperationID: op, ItemID: item, CreatedAt: at}
+	}
+	rollup := func(date string, sales ...model.JsonSale) *model.DailyRollup {
+		day := NewDailyRollup(date)
+		for _, s := range sales {
+			AddSaleToRollup(day, s)
+		}
+		return day
+	}
+
+	tests := []struct {
+		name string
+		// the last day is taken out again
+		days           []*model.DailyRollup
+		wantOperations int
+	}{
+		{"same operation and item", []*model.DailyRollup{rollup("a", sale(1, 1, 1, 5)), rollup("b", sale(1, 1, 1, 8))}, 1},
+		{"day with its own operation", []*model.DailyRollup{rollup("a", sale(1, 1, 1, 5)), rollup("b", sale(2, 2, 2, 8))}, 1},
+		{"only day", []*model.DailyRollup{rollup("a", sale(1, 1, 1, 5), sale(1, 2, 1, 3))}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := MergeRollups(tt.days)
+			SubtractRollup(merged, tt.days[len(tt.days)-1])
+			want := MergeRollups(tt.days[:len(tt.days)-1])
+
+			if merged.Total != want.Total {
+				t.Errorf("total = %+v, want %+v", merged.Total, want.Total)
+			}
+			if !reflect.DeepEqual(merged.Items, want.Items) {
+				t.Errorf("items = %v, want %v", merged.Items, want.Items)
+			}
+			if !reflect.DeepEqual(merged.Payments, want.Payments) {
+				t.Errorf("payments = %v, want %v", merged.Payments, want.Payments)
+			}
+			if len(merged.Operations) != tt.wantOperations {
+				t.Errorf("operations = %d, want %d", len(merged.Operations), tt.wantOperations)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package helper
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// Daily targets are paced over these hours instead of the whole day, nobody buys juice at 3am
+const (
+	dayOpenHour  = 8
+	dayCloseHour = 20
+)
+
+func ValidateTarget(t *model.Target) error {
+	if t.Period != BucketDay && t.Period != BucketMonth {
+		return errors.New("Target period must be day or month")
+	}
+	if t.Metric != "revenue" && t.Metric != "units" {
+		return errors.New("Target metric must be revenue or units")
+	}
+	if t.Amount <= 0 {
+		return errors.New("Target amount must be more than 0")
+	}
+	return nil
+}
+
+// Human readable name, e.g. "Monthly revenue - MD2 Cold Pressed"
+func TargetLabel(t *model.Target) string {
+	label := "Daily "
+	if t.Period == BucketMonth {
+		label = "Monthly "
+	}
+	label += t.Metric
+	if t.OperationID != 0 {
+		name, err := ParseOperationToString(t.OperationID)
+		if err != nil {
+			name = "Unknown"
+		}
+		label += " - " + name
+	}
+	if t.ItemID != 0 {
+		name, err := ParseItemToString(t.ItemID)
+		if err != nil {
+			name = "Unknown"
+		}
+		label += " - " + name
+	}
+	return label
+}
+
+// How much of the period has gone by, 0 to 1
+func periodElapsed(period string, now time.Time) float64 {
+	if period == BucketMonth {
+		start := BucketStart(now, BucketMonth)
+		end := start.AddDate(0, 1, 0)
+		return now.Sub(start).Hours() / end.Sub(start).Hours()
+	}
+
+	day := BucketStart(now, BucketDay)
+	open := day.Add(dayOpenHour * time.Hour)
+	close := day.Add(dayCloseHour * time.Hour)
+	return math.Min(1, math.Max(0, now.Sub(open).Hours()/close.Sub(open).Hours()))
+}
+
+// Progress of every target for the current day/month. days has to cover at least the current month.
+func CalculateTargetProgress(targets []*model.Target, days []*model.DailyRollup, now time.Time) []*model.ViewTargetProgress {
+	progress := []*model.ViewTargetProgress{}
+	for _, t := range targets {
+		start := BucketStart(now, t.Period).Format("2006-01-02")
+		today := now.Format("2006-01-02")
+
+		var actual float64
+		for _, day := range RollupsBetween(days, start, today) {
+			a := targetAggregate(t, day)
+			if a == nil {
+				continue
+			}
+			if t.Metric == "units" {
+				actual += a.Qty
+			} else {
+				actual += a.Amount
+			}
+		}
+
+		// before opening we have no pace to go on, so just project what we have
+		projected := actual
+		if elapsed := periodElapsed(t.Period, now); elapsed > 0 {
+			projected = actual / elapsed
+		}
+
+		progress = append(progress, &model.ViewTargetProgress{
+			Target:           t,
+			Label:            TargetLabel(t),
+			Actual:           RoundTo(actual, 2),
+			Percent:          RoundTo(actual/t.Amount*100, 2),
+			Projected:        RoundTo(projected, 2),
+			ProjectedPercent: RoundTo(projected/t.Amount*100, 2),
+		})
+	}
+	return progress
+}
+
+// The part of the day the target counts (an operation, a product, both or everything), nil when nothing was sold there
+func targetAggregate(t *model.Target, day *model.DailyRollup) *model.SalesAggregate {
+	if t.OperationID == 0 {
+		if t.ItemID == 0 {
+			return &day.Total
+		}
+		return day.Items[t.ItemID]
+	}
+
+	op, ok := day.Operations[t.OperationID]
+	if !ok {
+		return nil
+	}
+	if t.ItemID == 0 {
+		return &op.SalesAggregate
+	}
+	return op.Items[t.ItemID]
+}
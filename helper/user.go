@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+const MinPasswordLength = 10
+
+// A few passwords that pass the rules below and still get tried first
+var commonPasswords = []string{"password123", "password12", "qwerty1234", "abcd123456", "abc1234567", "iloveyou12", "welcome123", "letmein123"}
+
+// Length, a letter and a digit, not the identity and not one of the usual suspects
+func ValidatePassword(password string, identity string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters", MinPasswordLength)
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return errors.New("Password must have at least one letter and one number")
+	}
+
+	lower := strings.ToLower(password)
+	if identity != "" {
+		name := strings.ToLower(identity)
+		if at := strings.Index(name, "@"); at > 0 {
+			name = name[:at]
+		}
+		if strings.Contains(lower, name) {
+			return errors.New("Password can't contain your identity")
+		}
+	}
+	for _, common := range commonPasswords {
+		if lower == common {
+			return errors.New("Password is too common, pick another")
+		}
+	}
+	return nil
+}
+
+func ValidateNewUser(u *model.FormUser) error {
+	u.Identity = strings.TrimSpace(u.Identity)
+	if u.Identity == "" {
+		return errors.New("Identity is required")
+	}
+	if strings.ContainsAny(u.Identity, " \t/") {
+		return errors.New("Identity can't have spaces or slashes")
+	}
+	if !ValidRole(u.Role) {
+		return errors.New("Pick one of the roles")
+	}
+	return ValidatePassword(u.Password, u.Identity)
+}
@@ -0,0 +1,225 @@
+// Package logger writes leveled, structured log lines as logfmt (default) or JSON.
+// Fields are key-value pairs after the message:
+//
+//	logger.Info("Sale created", "operation_id", 3, "amount", 12.5)
+//
+// Request-scoped loggers come from FromContext, which adds the request ID set by the middleware.
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stderr
+	level            = LevelInfo
+	asJSON           = false
+)
+
+// Sets the minimum level and the format ("logfmt" or "json"), call once at startup
+func Init(l Level, format string) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+	asJSON = format == "json"
+}
+
+// Logger adds its fields to every line it writes
+type Logger struct {
+	fields []interface{}
+}
+
+var std = &Logger{}
+
+// A logger with these key-value pairs on every line
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{fields: fields}
+}
+
+func Debug(msg string, kv ...interface{}) { std.write(LevelDebug, msg, kv) }
+func Info(msg string, kv ...interface{})  { std.write(LevelInfo, msg, kv) }
+func Warn(msg string, kv ...interface{})  { std.write(LevelWarn, msg, kv) }
+func Error(msg string, kv ...interface{}) { std.write(LevelError, msg, kv) }
+
+// Logs at error level and exits with status 1
+func Fatal(msg string, kv ...interface{}) {
+	std.write(LevelError, msg, kv)
+	os.Exit(1)
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.write(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.write(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.write(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.write(LevelError, msg, kv) }
+
+type requestIDKey struct{}
+
+// Carries the request ID along to the store and the logs
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// The request ID in ctx, empty when there is none (startup, background work)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// A logger with the request ID in ctx on every line
+func FromContext(ctx context.Context) *Logger {
+	if id := RequestID(ctx); id != "" {
+		return std.With("request_id", id)
+	}
+	return std
+}
+
+// Milliseconds since t, for duration_ms fields
+func Millis(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+func (l *Logger) write(lvl Level, msg string, kv []interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if lvl < level {
+		return
+	}
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(kv))
+	fields = append(fields, "time", time.Now().Format("2006-01-02T15:04:05.000Z07:00"), "level", lvl.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	// a key without a value still shows up
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	var line []byte
+	if asJSON {
+		line = encodeJSON(fields)
+	} else {
+		line = encodeLogfmt(fields)
+	}
+	out.Write(append(line, '\n'))
+}
+
+func encodeLogfmt(fields []interface{}) []byte {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fmt.Sprint(fields[i]))
+		b.WriteByte('=')
+		s := format(fields[i+1])
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n\\") {
+			s = strconv.Quote(s)
+		}
+		b.WriteString(s)
+	}
+	return []byte(b.String())
+}
+
+var errNotNative = errors.New("not a JSON number or bool")
+
+func encodeJSON(fields []interface{}) []byte {
+	// written by hand to keep time, level and msg first
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(fmt.Sprint(fields[i]))
+		b.Write(k)
+		b.WriteByte(':')
+
+		var v []byte
+		var err error
+		switch val := fields[i+1].(type) {
+		case int, int64, uint64, float32, float64, bool:
+			v, err = json.Marshal(val)
+		default:
+			err = errNotNative
+		}
+		if err != nil {
+			// NaN and friends end up here too
+			v, _ = json.Marshal(format(fields[i+1]))
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return []byte(b.String())
+}
+
+func format(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case time.Duration:
+		return val.String()
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
@@ -1,57 +1,283 @@
 package main
 
 import (
-	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/CRTOsp3ck/mims-app/certs"
+	"github.com/CRTOsp3ck/mims-app/config"
 	"github.com/CRTOsp3ck/mims-app/handler"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/outbox"
+	"github.com/CRTOsp3ck/mims-app/rollup"
+	"github.com/CRTOsp3ck/mims-app/session"
+	"github.com/CRTOsp3ck/mims-app/store"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/idempotency"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"github.com/gofiber/template/html/v2"
 )
 
 func main() {
-	// Create a new engine
+	os.Exit(run())
+}
+
+// Runs the app until it's stopped, returns the exit code once the defers have closed everything
+func run() int {
+	// Settings from config.yaml, .env and the environment
+	cfg, err := config.Load()
+	if err != nil {
+		// a line for each problem found
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				logger.Error("Invalid configuration", "err", e)
+			}
+			return 1
+		}
+		logger.Error("Invalid configuration", "err", err)
+		return 1
+	}
+	level, _ := logger.ParseLevel(cfg.LogLevel)
+	logger.Init(level, cfg.LogFormat)
+	if cfg.File != "" {
+		logger.Info("Loaded config file", "file", cfg.File)
+	}
+
+	// Who is behind each login token, and their role
+	if err := session.Init(cfg); err != nil {
+		logger.Error("Unable to open sessions", "err", err)
+		return 1
+	}
+	defer session.Current().Close()
+
+	// Open the store (datastore API server or embedded db)
+	if err := store.Init(cfg); err != nil {
+		logger.Error("Unable to open store", "err", err)
+		return 1
+	}
+	defer store.Current().Close()
+
+	// Sales that couldn't reach the datastore wait here until they can
+	if err := outbox.Init(cfg); err != nil {
+		logger.Error("Unable to open outbox", "err", err)
+		return 1
+	}
+	defer outbox.Current().Close()
+
+	// Daily totals the reports and charts read
+	if err := rollup.Init(cfg); err != nil {
+		logger.Error("Unable to open rollups", "err", err)
+		return 1
+	}
+	defer rollup.Current().Close()
+	stopOutbox := make(chan struct{})
+	outboxDone := make(chan struct{})
+	go func() {
+		outbox.Current().Run(stopOutbox)
+		close(outboxDone)
+	}()
+
+	// Sales counters and scrape-time gauges for /metrics
+	handler.InitMetrics(cfg)
+	// Secure flag on the auth and CSRF cookies
+	handler.InitCookies(cfg)
+	// Rate limits and lockout on login
+	handler.InitLogin(cfg)
+	// CSP, framing, referrer and HSTS headers
+	handler.InitHeaders(cfg)
+
+	// Create a new engine, loaded now so a broken template stops startup instead of the first page
 	engine := html.New("./views", ".html")
+	if err := engine.Load(); err != nil {
+		logger.Error("Unable to load views", "err", err)
+		return 1
+	}
 
 	// Pass the engine to the Views
 	app := fiber.New(fiber.Config{
 		Views: engine,
+		// startup is logged by us
+		DisableStartupMessage: true,
+	})
+
+	// Request ID (taken from X-Request-ID or made up) on every log line and datastore call, and one log line per request
+	app.Use(requestid.New(requestid.Config{Header: store.RequestIDHeader, ContextKey: handler.RequestIDLocal}))
+	app.Use(handler.RequestLog)
+	app.Use(handler.RequestMetrics)
+
+	// Security headers and the CSP nonce for inline scripts
+	app.Use(handler.SecurityHeaders)
+	// CSRF token on every page, checked on every POST
+	app.Use(handler.CSRF)
+	// "Remember me" browsers whose session is over get a new one
+	app.Use("/main", handler.RestoreSession)
+	// Layout values (pending sync count) for every page
+	app.Use("/main", handler.LayoutData)
+
+	// A repeated new-sale submission (double tap, browser retry) gets the first answer back instead of a second sale
+	saleIdempotency := idempotency.New(idempotency.Config{
+		Lifetime:            30 * time.Minute,
+		KeyHeader:           handler.IdempotencyKeyHeader,
+		KeyHeaderValidate:   handler.ValidateIdempotencyKey,
+		KeepResponseHeaders: []string{fiber.HeaderLocation, fiber.HeaderContentType},
 	})
 
+	// Routes under /main check the caller's role with handler.Require (permissions in helper/rbac.go)
+
 	// --> Landing
 	// Home
 	app.Get("/", handler.Landing)
 	// Dashboard
-	app.Get("/main", handler.Dashboard)
+	app.Get("/main", handler.Require(helper.PermDashboard), handler.Dashboard)
 
 	// --> Auth
 	// Auth - Login page
 	app.Get("/main/login", handler.LoginPage)
 	// Auth - Login request
-	app.Post("/auth/login", handler.LoginRequest)
+	app.Post("/auth/login", handler.LoginIPLimit(), handler.LoginRequest)
 	// Auth - Logout
 	app.Post("/auth/logout", handler.LogoutRequest)
 
 	// --> Sales
 	// New Sale
-	app.Get("/main/new-sale", handler.NewSale)
+	app.Get("/main/new-sale", handler.Require(helper.PermRecordSales), handler.NewSale)
 	// POST New Sale
-	app.Post("/main/new-sale/", handler.NewSaleRequest)
+	app.Post("/main/new-sale/", handler.Require(helper.PermRecordSales), handler.IdempotencyKeyFromForm, saleIdempotency, handler.NewSaleRequest)
 	// Sales history
-	app.Get("/main/sales-history", handler.SalesHistory)
+	app.Get("/main/sales-history", handler.Require(helper.PermSalesHistory), handler.SalesHistory)
 	// Sales report
-	app.Get("/main/sales-report", handler.SalesReport)
+	app.Get("/main/sales-report", handler.Require(helper.PermReports), handler.SalesReport)
 	// POST Update periodic sales report
-	app.Post("/main/sales-report/update-periodic", handler.SalesReportUpdatePeriodic)
+	app.Post("/main/sales-report/update-periodic", handler.Require(helper.PermReports), handler.SalesReportUpdatePeriodic)
+	// POST Rebuild the daily totals behind the report
+	app.Post("/main/sales-report/rebuild", handler.Require(helper.PermReports), handler.SalesReportRebuildRequest)
+	// Sales report chart data (JSON)
+	app.Get("/main/sales-report/chart/:chart", handler.Require(helper.PermReports), handler.SalesReportChart)
+	// Sales report hourly heatmap (JSON)
+	app.Get("/main/sales-report/heatmap", handler.Require(helper.PermReports), handler.SalesReportHeatmap)
+
+	// --> Forecast
+	// Demand forecast
+	app.Get("/main/forecast", handler.Require(helper.PermReports), handler.Forecast)
+	// Demand forecast (JSON)
+	app.Get("/main/forecast/json", handler.Require(helper.PermReports), handler.ForecastJSON)
+
+	// --> Targets
+	// Targets and progress
+	app.Get("/main/targets", handler.Require(helper.PermTargets), handler.Targets)
+	// POST New target
+	app.Post("/main/targets", handler.Require(helper.PermManageTargets), handler.NewTargetRequest)
+	// POST Delete target
+	app.Post("/main/targets/:id/delete", handler.Require(helper.PermManageTargets), handler.DeleteTargetRequest)
 
 	// --> Purchases
 	// Add purchase
-	app.Get("/main/add-purchase", handler.AddPurchase)
+	app.Get("/main/add-purchase", handler.Require(helper.PermPurchases), handler.AddPurchase)
 	// List purchase
-	app.Get("/main/purchase-history", handler.ListPurchase)
+	app.Get("/main/purchase-history", handler.Require(helper.PermPurchases), handler.ListPurchase)
+
+	// --> Sync review
+	// Queued sales that may already be in the datastore
+	app.Get("/main/sync-review", handler.Require(helper.PermReviewSync), handler.SyncReview)
+	// POST Settle one
+	app.Post("/main/sync-review/:id", handler.Require(helper.PermReviewSync), handler.SyncReviewRequest)
+
+	// --> Users
+	// Staff accounts
+	app.Get("/main/users", handler.Require(helper.PermManageUsers), handler.Users)
+	// POST New user
+	app.Post("/main/users", handler.Require(helper.PermManageUsers), handler.NewUserRequest)
+	// POST Disable user
+	app.Post("/main/users/disable", handler.Require(helper.PermManageUsers), handler.DisableUserRequest)
+	// POST Enable user
+	app.Post("/main/users/enable", handler.Require(helper.PermManageUsers), handler.EnableUserRequest)
+	// POST Reset a user's password
+	app.Post("/main/users/reset-password", handler.Require(helper.PermManageUsers), handler.ResetPasswordRequest)
+	// Change own password, any role (and the only page open until a forced change is done)
+	app.Get("/main/account/password", handler.ChangePassword)
+	// POST Change own password
+	app.Post("/main/account/password", handler.ChangePasswordRequest)
+
+	// --> Health
+	// /healthz and /readyz are open to anyone, the rest need METRICS_TOKEN or a request from this machine
+	// Datastore and circuit breaker state (JSON)
+	app.Get("/health/datastore", handler.MonitoringAuth, handler.DatastoreHealth)
+	// Liveness (JSON)
+	app.Get("/healthz", handler.Healthz)
+	// Readiness, store reachable and views loaded (JSON)
+	app.Get("/readyz", handler.Readyz)
+	// Prometheus metrics
+	app.Get("/metrics", handler.MonitoringAuth, handler.Metrics)
 
 	// Static file server
 	app.Static("/static", "./static")
 
-	// Http server
-	log.Fatal(app.Listen(":3000"))
+	// Http server, https when TLS is on
+	listenErr := make(chan error, 2)
+	if cfg.TLS.Mode == "off" {
+		go func() {
+			listenErr <- app.Listen(cfg.Addr())
+		}()
+		logger.Info("Listening", "addr", cfg.Addr())
+	} else {
+		cert, err := certs.Load(cfg)
+		if err != nil {
+			logger.Error("Unable to start https", "err", err)
+			return 1
+		}
+		go func() {
+			listenErr <- app.ListenTLSWithCertificate(cfg.Addr(), cert)
+		}()
+		logger.Info("Listening", "addr", cfg.Addr(), "tls", cfg.TLS.Mode)
+	}
+
+	// Plain http port that only sends browsers to https
+	var redirect *fiber.App
+	if addr := cfg.RedirectAddr(); addr != "" {
+		redirect = fiber.New(fiber.Config{DisableStartupMessage: true})
+		redirect.Use(handler.HTTPSRedirect(cfg.Port))
+		go func() {
+			listenErr <- redirect.Listen(addr)
+		}()
+		logger.Info("Redirecting http to https", "addr", addr)
+	}
+
+	// Stop on SIGINT/SIGTERM: let in-flight requests finish, stop the outbox worker and give
+	// waiting sales one more go before the dbs are closed by the defers above
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	exitCode := 0
+	select {
+	case err := <-listenErr:
+		// still shut down below so the outbox gets its last go and the dbs are closed
+		logger.Error("Unable to start server", "err", err)
+		exitCode = 1
+	case sig := <-signals:
+		logger.Info("Shutting down", "signal", sig)
+	}
+
+	handler.ShuttingDown()
+	if err := app.ShutdownWithTimeout(cfg.ShutdownTimeout); err != nil {
+		logger.Error("Error draining requests", "err", err)
+	}
+	if redirect != nil {
+		if err := redirect.Shutdown(); err != nil {
+			logger.Error("Error stopping http redirect", "err", err)
+		}
+	}
+
+	close(stopOutbox)
+	<-outboxDone
+	if store.Current().Health().Available {
+		if n := outbox.Current().Flush(); n > 0 {
+			logger.Warn("Outbox still has sales waiting, they will sync on the next start", "pending", n)
+		}
+	} else if n := outbox.Current().Pending(); n > 0 {
+		logger.Warn("Datastore unavailable, outbox sales will sync on the next start", "pending", n)
+	}
+	logger.Info("Stopped")
+	return exitCode
 }
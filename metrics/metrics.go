@@ -0,0 +1,263 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text
+// format for /metrics. Only what the app needs: label values are given in the order the labels
+// were declared, and everything registers itself on creation.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Seconds, from a quick local call to a slow datastore over a bad connection
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]metric{}
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	registry[name] = m
+}
+
+// Writes every metric, sorted by name
+func Write(w io.Writer) {
+	registryMu.Lock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	metrics := make([]metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, registry[name])
+	}
+	registryMu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Label names and values of one series
+type series struct {
+	key    string
+	values []string
+}
+
+type vec struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(name string, help string, kind string, labels []string) vec {
+	return vec{name: name, help: help, kind: kind, labels: labels, series: map[string]*series{}}
+}
+
+func (v *vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		// copied, fiber hands out strings backed by buffers it reuses
+		s = &series{key: key, values: make([]string, len(values))}
+		for i, value := range values {
+			s.values[i] = strings.Clone(value)
+		}
+		v.series[key] = s
+	}
+	return s
+}
+
+// Series sorted by label values so the output is stable
+func (v *vec) sorted() []*series {
+	all := make([]*series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].key < all[j].key })
+	return all
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// {a="1",b="2"} with extra pairs (le for buckets) after the series' own
+func (v *vec) labelString(s *series, extra ...string) string {
+	pairs := []string{}
+	for i, l := range v.labels {
+		pairs = append(pairs, l+`="`+labelEscaper.Replace(s.values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter only goes up
+type Counter struct {
+	vec
+	values map[string]float64
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec(name, help, "counter", labels), values: map[string]float64{}}
+	register(name, c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.get(labelValues).key] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(s), formatFloat(c.values[s.key]))
+	}
+}
+
+// Gauge goes up and down, or is read with fn when scraped (NewGaugeFunc)
+type Gauge struct {
+	vec
+	values map[string]float64
+	fn     func() float64
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", labels), values: map[string]float64{}}
+	register(name, g)
+	return g
+}
+
+// A gauge without labels whose value is fn's at scrape time
+func NewGaugeFunc(name string, help string, fn func() float64) *Gauge {
+	g := &Gauge{vec: newVec(name, help, "gauge", nil), fn: fn}
+	register(name, g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.get(labelValues).key] = v
+}
+
+func (g *Gauge) write(w io.Writer) {
+	if g.fn != nil {
+		v := g.fn()
+		g.header(w)
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(v))
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, s := range g.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelString(s), formatFloat(g.values[s.key]))
+	}
+}
+
+// Histogram counts observations into buckets (upper bounds, ascending)
+type Histogram struct {
+	vec
+	buckets []float64
+	values  map[string]*histogramValues
+}
+
+type histogramValues struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: newVec(name, help, "histogram", labels), buckets: buckets, values: map[string]*histogramValues{}}
+	register(name, h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := h.get(labelValues).key
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValues{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, s := range h.sorted() {
+		hv := h.values[s.key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s, "le", formatFloat(upper)), hv.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(s, "le", "+Inf"), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(s), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(s), hv.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
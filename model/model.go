@@ -59,3 +59,240 @@ type Dates struct {
 	StartDate string `json:"periodic_sd" xml:"periodic_sd" form:"periodic_sd"`
 	EndDate   string `json:"periodic_ed" xml:"periodic_ed" form:"periodic_ed"`
 }
+
+// one row per payment type, so we know how much is in the cash box vs the bank
+type ViewPaymentBreakdown struct {
+	PaymentType string  `json:"payment_type"`
+	Count       int     `json:"count"`
+	Qty         float64 `json:"qty"`
+	Amount      float64 `json:"amount"`
+	ListValue   float64 `json:"list_value"` //value of the items at list price (this is what we gave away for "Free")
+	Share       float64 `json:"share"`      //percentage of total amount
+}
+
+// one row per operation or product in the sales report
+type ViewRevenueBreakdown struct {
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	Count         int     `json:"count"`
+	Qty           float64 `json:"qty"`
+	Amount        float64 `json:"amount"`
+	AverageTicket float64 `json:"average_ticket"`
+	Share         float64 `json:"share"` //percentage of total amount
+}
+
+type SalesHistoryFilter struct {
+	OperationID int    `query:"operation"`
+	ItemID      int    `query:"item"`
+	StartDate   string `query:"sd"`
+	EndDate     string `query:"ed"`
+}
+
+// shaped so it can be handed straight to ApexCharts/Highcharts (xaxis categories + series)
+type ChartData struct {
+	Categories []string       `json:"categories"`
+	Series     []*ChartSeries `json:"series"`
+}
+
+type ChartSeries struct {
+	Name string    `json:"name"`
+	Data []float64 `json:"data"`
+}
+
+type ChartQuery struct {
+	StartDate string `query:"sd"`
+	EndDate   string `query:"ed"`
+	Bucket    string `query:"bucket"` //day, week or month
+}
+
+// ApexCharts heatmap format, one series per weekday and one cell per hour of operation
+type HeatmapCell struct {
+	X string  `json:"x"`
+	Y float64 `json:"y"`
+}
+
+type HeatmapSeries struct {
+	Name string         `json:"name"`
+	Data []*HeatmapCell `json:"data"`
+}
+
+type ViewHeatmap struct {
+	ItemID  int              `json:"item_id"`
+	Product string           `json:"product"`
+	Series  []*HeatmapSeries `json:"series"`
+}
+
+type ViewDashboard struct {
+	Greeting         string                  `json:"greeting"`
+	TodayRevenue     float64                 `json:"today_revenue"`
+	TodaySales       int                     `json:"today_sales"`
+	TodayUnits       float64                 `json:"today_units"`
+	ThisWeekRevenue  float64                 `json:"this_week_revenue"`
+	LastWeekRevenue  float64                 `json:"last_week_revenue"` //same point in time last week, so the comparison is fair
+	WeekChange       float64                 `json:"week_change"`       //percentage
+	MonthToDate      ViewSalesReport         `json:"month_to_date"`
+	TopProducts      []*ViewRevenueBreakdown `json:"top_products"`
+	OperationsStatus []*ViewOperationStatus  `json:"operations_status"`
+}
+
+type ViewOperationStatus struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	OpenedAt string  `json:"opened_at"`
+	LastSale string  `json:"last_sale"`
+	Revenue  float64 `json:"revenue"`
+}
+
+// current vs previous value of one report line
+type ViewReportDelta struct {
+	Name          string  `json:"name"`
+	Current       float64 `json:"current"`
+	Previous      float64 `json:"previous"`
+	Change        float64 `json:"change"`
+	ChangePercent float64 `json:"change_percent"`
+}
+
+type ViewPeriodComparison struct {
+	Label     string             `json:"label"`
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`
+	Report    []*ViewReportDelta `json:"report"`   //every ViewSalesReport field
+	Products  []*ViewReportDelta `json:"products"` //units per product
+}
+
+type ViewForecast struct {
+	OperationID   int                    `json:"operation_id"`
+	Operation     string                 `json:"operation"`
+	Date          string                 `json:"date"`
+	Weekday       string                 `json:"weekday"`
+	DaysOfHistory int                    `json:"days_of_history"`
+	Products      []*ViewProductForecast `json:"products"`
+	FruitToBuy    int                    `json:"fruit_to_buy"` //suggested raw fruit purchase
+}
+
+type ViewProductForecast struct {
+	ItemID        int     `json:"item_id"`
+	Product       string  `json:"product"`
+	Units         float64 `json:"units"`
+	Level         float64 `json:"level"`
+	Trend         float64 `json:"trend"`
+	WeekdayFactor float64 `json:"weekday_factor"`
+	SeasonFactor  float64 `json:"season_factor"`
+}
+
+type ForecastQuery struct {
+	OperationID int    `query:"operation"`
+	Date        string `query:"date"`
+}
+
+type Target struct {
+	ID          int     `json:"id" xml:"id" form:"id"`
+	Period      string  `json:"period" xml:"period" form:"period"`                   //day or month
+	Metric      string  `json:"metric" xml:"metric" form:"metric"`                   //revenue or units
+	OperationID int     `json:"operation_id" xml:"operation_id" form:"operation_id"` //0 means every operation
+	ItemID      int     `json:"item_id" xml:"item_id" form:"item_id"`                //0 means every product
+	Amount      float64 `json:"amount" xml:"amount" form:"amount"`
+}
+
+type ViewTargetProgress struct {
+	Target           *Target `json:"target"`
+	Label            string  `json:"label"`
+	Actual           float64 `json:"actual"`
+	Percent          float64 `json:"percent"`
+	Projected        float64 `json:"projected"` //where we end up at the current pace
+	ProjectedPercent float64 `json:"projected_percent"`
+}
+
+// Totals of a group of sales
+type SalesAggregate struct {
+	Count     int     `json:"count"`
+	Qty       float64 `json:"qty"`
+	Amount    float64 `json:"amount"`
+	ListValue float64 `json:"list_value"` //value of the items at list price
+}
+
+// Everything sold on one day, the reports and charts read these instead of going through every sale
+type DailyRollup struct {
+	Date       string                   `json:"date"` //YYYY-MM-DD, local time
+	Total      SalesAggregate           `json:"total"`
+	Hours      map[int]float64          `json:"hours"` //amount per hour of the day (0-23)
+	Items      map[int]*SalesAggregate  `json:"items"`
+	Payments   map[int]*SalesAggregate  `json:"payments"`
+	Operations map[int]*OperationRollup `json:"operations"`
+}
+
+type OperationRollup struct {
+	SalesAggregate
+	FirstSale time.Time               `json:"first_sale"` //taken as the time the operation opened
+	LastSale  time.Time               `json:"last_sale"`
+	Items     map[int]*SalesAggregate `json:"items"`
+	Hours     map[int]map[int]float64 `json:"hours"` //units per item per hour of operation (0 is the first hour)
+}
+
+// Roles a user can have, what each may do is in helper.Can
+const (
+	RoleOwner      = "owner"
+	RoleCashier    = "cashier"
+	RoleAccountant = "accountant"
+	RoleViewer     = "viewer"
+)
+
+// Who is behind a token, kept by the app after login
+type Session struct {
+	Identity  string    `json:"identity"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	Expires   time.Time `json:"expires"`
+	// everything but the change password page is off until it's changed
+	MustChangePassword bool `json:"must_change_password,omitempty"`
+}
+
+// How a login attempt went
+const (
+	LoginSuccess            = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginDisabled           = "disabled"
+	LoginLocked             = "locked"
+	LoginRateLimited        = "rate_limited"
+	LoginUnavailable        = "unavailable" //the store couldn't be reached
+	LoginError              = "error"
+)
+
+// One try at /auth/login, kept for the Users page and to work out lockouts
+type LoginAttempt struct {
+	Time      time.Time `json:"time"`
+	Identity  string    `json:"identity"`
+	IP        string    `json:"ip"`
+	Result    string    `json:"result"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// A "remember me" login, lets the browser back in after its session is over.
+// Token is the store's, kept so it can be handed back in a new cookie (sealed with the refresh token in the session db).
+type RememberMe struct {
+	Identity string    `json:"identity"`
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+}
+
+// A staff account, the password itself stays with the store
+type User struct {
+	Identity           string    `json:"identity"`
+	Role               string    `json:"role"`
+	Disabled           bool      `json:"disabled,omitempty"`
+	MustChangePassword bool      `json:"must_change_password,omitempty"` //set for new accounts and after a reset
+	CreatedAt          time.Time `json:"created_at"`
+	CreatedBy          string    `json:"created_by,omitempty"`
+}
+
+type FormUser struct {
+	Identity string `json:"identity" xml:"identity" form:"identity"`
+	Password string `json:"password" xml:"password" form:"password"`
+	Role     string `json:"role" xml:"role" form:"role"`
+}
+
+type FormPasswordChange struct {
+	CurrentPassword string `json:"current_password" xml:"current_password" form:"current_password"`
+	NewPassword     string `json:"new_password" xml:"new_password" form:"new_password"`
+	ConfirmPassword string `json:"confirm_password" xml:"confirm_password" form:"confirm_password"`
+}
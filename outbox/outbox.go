@@ -0,0 +1,472 @@
+// Package outbox keeps sales that couldn't reach the datastore in a BoltDB file and sends them
+// once it's back. Market Wi-Fi drops all the time, a sale should never be lost because of it.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketPending = []byte("pending")
+	// upstream sale IDs already matched to a queued sale, so two identical queued sales can't both claim one
+	bucketClaimed = []byte("claimed")
+)
+
+const (
+	pollInterval = 5 * time.Second
+	minBackoff   = 5 * time.Second
+	maxBackoff   = 5 * time.Minute
+	// how long a claimed upstream sale ID is remembered
+	claimLength = 7 * 24 * time.Hour
+	// slack around an in-doubt attempt when looking for the sale it may have created
+	matchWindow = 30 * time.Second
+)
+
+// A sale waiting to be sent to the datastore
+type Entry struct {
+	ID string `json:"id"`
+	// who rang it up, it's only ever sent with their token
+	Identity    string         `json:"identity,omitempty"`
+	Token       string         `json:"token"`
+	Sale        model.JsonSale `json:"sale"`
+	QueuedAt    time.Time      `json:"queued_at"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"next_attempt"`
+	LastError   string         `json:"last_error"`
+	// request that queued the sale, sent along with every retry so the logs line up
+	RequestID string `json:"request_id,omitempty"`
+	// attempts that failed after the request may have reached the datastore
+	InDoubt []time.Time `json:"in_doubt"`
+	// The datastore has a sale like this one from around an in-doubt attempt. It may be this sale or
+	// another one just like it rung up at the same time, the datastore can't tell us which, so it's
+	// left for someone to check (Resolve) rather than dropped or sent again.
+	Review  bool   `json:"review,omitempty"`
+	MatchID uint64 `json:"match_id,omitempty"`
+}
+
+var ErrNotFound = errors.New("No such sale waiting for review")
+
+type Outbox struct {
+	db    *bolt.DB
+	store store.Store
+	wake  chan struct{}
+	// one sync pass at a time, the worker and Flush share it
+	mu sync.Mutex
+}
+
+var current *Outbox
+
+// Opens the outbox at OUTBOX_PATH for the current store, call once after store.Init
+func Init(cfg *config.Config) error {
+	o, err := Open(cfg.OutboxPath, store.Current())
+	if err != nil {
+		return err
+	}
+
+	if n := o.Pending(); n > 0 {
+		logger.Info("Outbox has sales waiting to sync", "pending", n)
+	}
+	if n := len(o.Reviews()); n > 0 {
+		logger.Warn("Outbox has sales waiting for review", "review", n)
+	}
+	current = o
+	return nil
+}
+
+func Current() *Outbox {
+	return current
+}
+
+func Open(path string, s store.Store) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketPending, bucketClaimed} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return pruneClaimed(tx.Bucket(bucketClaimed), time.Now())
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Outbox{db: db, store: s, wake: make(chan struct{}, 1)}, nil
+}
+
+// Queues identity's sale that failed to post. sent is whether that failed attempt may have reached the datastore.
+func (o *Outbox) Enqueue(ctx context.Context, identity string, token string, sale *model.JsonSale, sent bool) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	e := &Entry{
+		ID:          id,
+		Identity:    identity,
+		Token:       token,
+		Sale:        *sale,
+		QueuedAt:    now,
+		Attempts:    1,
+		NextAttempt: now.Add(backoff(1)),
+		RequestID:   logger.RequestID(ctx),
+	}
+	// sent along with the sale, so it's counted when it was made rather than when it synced
+	e.Sale.CreatedAt = now
+	if sent {
+		e.InDoubt = append(e.InDoubt, now)
+	}
+
+	err = o.db.Update(func(tx *bolt.Tx) error {
+		return putEntry(tx, e)
+	})
+	if err == nil {
+		logger.FromContext(ctx).Warn("Sale queued for sync", "outbox_id", e.ID, "in_doubt", sent)
+	}
+	return err
+}
+
+// Number of sales still waiting to sync, not counting the ones waiting for review
+func (o *Outbox) Pending() int {
+	n := 0
+	o.db.View(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *Entry) error {
+			if !e.Review {
+				n++
+			}
+			return nil
+		})
+	})
+	return n
+}
+
+// Sales that may already be in the datastore, oldest first
+func (o *Outbox) Reviews() []*Entry {
+	entries := []*Entry{}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *Entry) error {
+			if e.Review {
+				entries = append(entries, e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error("Error reading outbox", "err", err)
+	}
+	return entries
+}
+
+// Settles a sale waiting for review. landed means the datastore's sale (MatchID) is this one, so it's dropped,
+// otherwise it's sent again.
+func (o *Outbox) Resolve(id string, landed bool) error {
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketPending).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		e := new(Entry)
+		if err := json.Unmarshal(v, e); err != nil {
+			return err
+		}
+		if !e.Review {
+			return ErrNotFound
+		}
+
+		if landed {
+			return tx.Bucket(bucketPending).Delete([]byte(id))
+		}
+		// someone else's sale, another queued sale may still turn out to be it
+		if err := tx.Bucket(bucketClaimed).Delete(itob(e.MatchID)); err != nil {
+			return err
+		}
+		e.Review = false
+		e.MatchID = 0
+		e.InDoubt = nil
+		e.NextAttempt = time.Now()
+		return putEntry(tx, e)
+	})
+	if err != nil || landed {
+		return err
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Hands identity's token that just worked to their queued sales (the one they were queued with may have
+// expired by now) and syncs right away. Nobody else's sales are sent with it.
+func (o *Outbox) Adopt(identity string, token string) {
+	if identity == "" || token == "" || o.Pending() == 0 {
+		return
+	}
+
+	err := o.db.Update(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *Entry) error {
+			if e.Identity != identity {
+				return nil
+			}
+			e.Token = token
+			e.NextAttempt = time.Now()
+			return putEntry(tx, e)
+		})
+	})
+	if err != nil {
+		logger.Error("Error updating outbox tokens", "err", err)
+		return
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Background worker, syncs due sales until stop is closed
+func (o *Outbox) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+		o.sync(false)
+	}
+}
+
+// Tries every queued sale once regardless of backoff, returns how many are still pending
+func (o *Outbox) Flush() int {
+	o.sync(true)
+	return o.Pending()
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+func (o *Outbox) sync(all bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := []*Entry{}
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return forEachEntry(tx, func(e *Entry) error {
+			entries = append(entries, e)
+			return nil
+		})
+	})
+	if err != nil {
+		logger.Error("Error reading outbox", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for _, e := range entries {
+		if e.Review || (!all && e.NextAttempt.After(now)) {
+			continue
+		}
+		ctx := logger.WithRequestID(context.Background(), e.RequestID)
+		if err := o.send(ctx, e); err != nil {
+			logger.FromContext(ctx).Warn("Error syncing queued sale", "outbox_id", e.ID, "attempts", e.Attempts, "err", err)
+		}
+	}
+}
+
+func (o *Outbox) send(ctx context.Context, e *Entry) error {
+	// an earlier attempt may have landed, look for it before sending again
+	if len(e.InDoubt) > 0 {
+		id, err := o.findLanded(ctx, e)
+		if err != nil {
+			return o.retry(e, err, false)
+		}
+		if id != 0 {
+			logger.FromContext(ctx).Warn("Queued sale may already be in datastore, waiting for review", "outbox_id", e.ID, "sale_id", id)
+			e.Review = true
+			e.MatchID = id
+			return o.db.Update(func(tx *bolt.Tx) error {
+				if err := tx.Bucket(bucketClaimed).Put(itob(id), []byte(time.Now().Format(time.RFC3339))); err != nil {
+					return err
+				}
+				return putEntry(tx, e)
+			})
+		}
+	}
+
+	sale := e.Sale
+	if err := o.store.CreateSale(ctx, e.Token, &sale); err != nil {
+		var unavailable *store.UnavailableError
+		sent := errors.As(err, &unavailable) && unavailable.Sent
+		return o.retry(e, err, sent)
+	}
+
+	logger.FromContext(ctx).Info("Queued sale synced", "outbox_id", e.ID, "attempts", e.Attempts)
+	return o.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketPending).Delete([]byte(e.ID))
+	})
+}
+
+// Upstream ID of a sale matching e created around one of its in-doubt attempts, 0 if none
+func (o *Outbox) findLanded(ctx context.Context, e *Entry) (uint64, error) {
+	start := e.InDoubt[0].Add(-matchWindow).Format("2006-01-02")
+	end := time.Now().Format("2006-01-02")
+	sales, err := o.store.FindSales(ctx, e.Token, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	var found uint64
+	err = o.db.View(func(tx *bolt.Tx) error {
+		claimed := tx.Bucket(bucketClaimed)
+		for _, s := range sales {
+			if s.ID <= 0 || claimed.Get(itob(uint64(s.ID))) != nil || !sameSale(s, e.Sale) {
+				continue
+			}
+			for _, at := range e.InDoubt {
+				if s.CreatedAt.After(at.Add(-matchWindow)) && s.CreatedAt.Before(at.Add(matchWindow)) {
+					found = uint64(s.ID)
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (o *Outbox) retry(e *Entry, cause error, sent bool) error {
+	now := time.Now()
+	e.Attempts++
+	e.NextAttempt = now.Add(backoff(e.Attempts))
+	e.LastError = cause.Error()
+	if sent {
+		e.InDoubt = append(e.InDoubt, now)
+	}
+
+	if err := o.db.Update(func(tx *bolt.Tx) error {
+		return putEntry(tx, e)
+	}); err != nil {
+		return err
+	}
+	return cause
+}
+
+// Doubles from minBackoff up to maxBackoff, with +-20% jitter so queued sales don't all go at once
+func backoff(attempts int) time.Duration {
+	d := minBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	jitter := time.Duration(mrand.Int63n(int64(d)/5*2+1)) - d/5
+	return d + jitter
+}
+
+func sameSale(a model.JsonSale, b model.JsonSale) bool {
+	return a.Amount == b.Amount &&
+		a.Qty == b.Qty &&
+		a.PaymentType == b.PaymentType &&
+		a.OperationID == b.OperationID &&
+		a.ItemID == b.ItemID &&
+		a.GroupSaleID == b.GroupSaleID
+}
+
+func pruneClaimed(b *bolt.Bucket, now time.Time) error {
+	stale := [][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		at, err := time.Parse(time.RFC3339, string(v))
+		if err != nil || now.Sub(at) > claimLength {
+			stale = append(stale, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range stale {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func forEachEntry(tx *bolt.Tx, fn func(e *Entry) error) error {
+	entries := []*Entry{}
+	err := tx.Bucket(bucketPending).ForEach(func(k, v []byte) error {
+		e := new(Entry)
+		if err := json.Unmarshal(v, e); err != nil {
+			logger.Error("Error reading queued sale", "outbox_id", string(k), "err", err)
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// fn may write to the bucket, which bolt doesn't allow while iterating it
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putEntry(tx *bolt.Tx, e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketPending).Put([]byte(e.ID), b)
+}
+
+// Sortable by queue time, then random so two sales in the same nanosecond don't collide
+func newID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(b), nil
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
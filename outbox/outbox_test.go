@@ -0,0 +1,182 @@
+package outbox
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Only FindSales and CreateSale are called by the outbox
+type fakeStore struct {
+	store.Store
+	sales []model.JsonSale
+	sent  int
+}
+
+func (s *fakeStore) FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error) {
+	return s.sales, nil
+}
+
+func (s *fakeStore) CreateSale(ctx context.Context, token string, sale *model.JsonSale) error {
+	s.sent++
+	return nil
+}
+
+func open(t *testing.T, s store.Store) *Outbox {
+	t.Helper()
+	o, err := Open(filepath.Join(t.TempDir(), "outbox.db"), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Close() })
+	return o
+}
+
+func TestFindLanded(t *testing.T) {
+	inDoubt := time.Now().Add(-time.Hour)
+	queued := model.JsonSale{Amount: 12.5, Qty: 1, PaymentType: 1, OperationID: 2, ItemID: 3}
+	like := func(id int, at time.Time) model.JsonSale {
+		s := queued
+		s.ID = id
+		s.CreatedAt = at
+		return s
+	}
+	other := like(7, inDoubt)
+	other.Qty = 2
+
+	tests := []struct {
+		name    string
+		sales   []model.JsonSale
+		claimed []uint64
+		want    uint64
+	}{
+		{"nothing in the datastore", nil, nil, 0},
+		{"same sale at the in-doubt attempt", []model.JsonSale{like(7, inDoubt.Add(2*time.Second))}, nil, 7},
+		{"same sale before the window", []model.JsonSale{like(7, inDoubt.Add(-matchWindow-time.Second))}, nil, 0},
+		{"same sale after the window", []model.JsonSale{like(7, inDoubt.Add(matchWindow+time.Second))}, nil, 0},
+		{"different sale at the same time", []model.JsonSale{other}, nil, 0},
+		{"no id", []model.JsonSale{like(0, inDoubt)}, nil, 0},
+		{"claimed by another queued sale", []model.JsonSale{like(7, inDoubt)}, []uint64{7}, 0},
+		{"the next one along when the first is claimed", []model.JsonSale{like(7, inDoubt), like(8, inDoubt.Add(time.Second))}, []uint64{7}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := open(t, &fakeStore{sales: tt.sales})
+			err := o.db.Update(func(tx *bolt.Tx) error {
+				for _, id := range tt.claimed {
+					if err := tx.Bucket(bucketClaimed).Put(itob(id), []byte(time.Now().Format(time.RFC3339))); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := o.findLanded(context.Background(), &Entry{Sale: queued, InDoubt: []time.Time{inDoubt}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("findLanded = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// A match is never taken as proof, the queued sale waits for someone to settle it
+func TestReview(t *testing.T) {
+	sale := model.JsonSale{Amount: 12.5, Qty: 1, PaymentType: 1, OperationID: 2, ItemID: 3}
+
+	tests := []struct {
+		name     string
+		landed   bool
+		wantSent int
+	}{
+		{"it was this sale", true, 0},
+		{"it was another sale", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeStore{}
+			o := open(t, s)
+			if err := o.Enqueue(context.Background(), "ann", "token", &sale, true); err != nil {
+				t.Fatal(err)
+			}
+			// someone else rings up the same sale a second later
+			match := sale
+			match.ID = 7
+			match.CreatedAt = time.Now().Add(time.Second)
+			s.sales = []model.JsonSale{match}
+
+			o.Flush()
+			reviews := o.Reviews()
+			if len(reviews) != 1 || reviews[0].MatchID != 7 || s.sent != 0 || o.Pending() != 0 {
+				t.Fatalf("after sync: reviews %v, sent %d, pending %d, want one review of #7 and nothing sent", reviews, s.sent, o.Pending())
+			}
+			// the sync leaves it alone
+			o.Flush()
+			if s.sent != 0 || len(o.Reviews()) != 1 {
+				t.Fatalf("sent a sale waiting for review")
+			}
+
+			if err := o.Resolve(reviews[0].ID, tt.landed); err != nil {
+				t.Fatal(err)
+			}
+			o.Flush()
+			if s.sent != tt.wantSent || o.Pending() != 0 || len(o.Reviews()) != 0 {
+				t.Errorf("after Resolve: sent %d, pending %d, reviews %d, want sent %d and nothing left", s.sent, o.Pending(), len(o.Reviews()), tt.wantSent)
+			}
+			if err := o.Resolve(reviews[0].ID, tt.landed); err != ErrNotFound {
+				t.Errorf("Resolve again = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+// Queued sales keep their own token until the same identity logs in again
+func TestAdopt(t *testing.T) {
+	sale := model.JsonSale{Amount: 8, Qty: 1, PaymentType: 1, OperationID: 1, ItemID: 1}
+
+	tests := []struct {
+		name     string
+		identity string
+		token    string
+		want     map[string]string // identity -> token of its queued sale
+	}{
+		{"same identity", "ann", "ann-new", map[string]string{"ann": "ann-new", "bob": "bob-old"}},
+		{"someone else", "carl", "carl-new", map[string]string{"ann": "ann-old", "bob": "bob-old"}},
+		{"no identity", "", "x", map[string]string{"ann": "ann-old", "bob": "bob-old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// no worker runs, so nothing syncs
+			o := open(t, &fakeStore{})
+			for _, identity := range []string{"ann", "bob"} {
+				if err := o.Enqueue(context.Background(), identity, identity+"-old", &sale, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			o.Adopt(tt.identity, tt.token)
+			got := map[string]string{}
+			o.db.View(func(tx *bolt.Tx) error {
+				return forEachEntry(tx, func(e *Entry) error {
+					got[e.Identity] = e.Token
+					return nil
+				})
+			})
+			for identity, token := range tt.want {
+				if got[identity] != token {
+					t.Errorf("%s's sale has token %q, want %q", identity, got[identity], token)
+				}
+			}
+		})
+	}
+}
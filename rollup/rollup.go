@@ -0,0 +1,374 @@
+// Package rollup keeps daily totals (per product, payment type and operation) in a BoltDB file next to
+// the app, so the reports and charts add up a row per day instead of going through every sale ever made.
+//
+// Days before the last one that may still get sales are final. That open day is rolled up again from
+// the store every ROLLUP_REFRESH (sales from other devices), sales made here are added as they happen.
+package rollup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/store"
+	"github.com/gofiber/fiber/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketDays = []byte("days")
+	bucketMeta = []byte("meta")
+	// the oldest day that may still get sales, everything before it is final
+	keyOpenDay = []byte("open_day")
+	// every day added up, kept in step with the days as they are written
+	keyLifetime = []byte("lifetime")
+)
+
+type Rollups struct {
+	db      *bolt.DB
+	store   store.Store
+	refresh time.Duration
+
+	// one roll up from the store at a time, held while the sales are fetched
+	refreshing sync.Mutex
+	// guards the open day and the fields below, never held while the store is called
+	mu          sync.Mutex
+	refreshedAt time.Time
+	// sales added so far, a roll up that overlapped an Add refreshes again on the next call
+	added int
+}
+
+var current *Rollups
+
+// Opens the rollups at ROLLUP_PATH for the current store, call once after store.Init
+func Init(cfg *config.Config) error {
+	r, err := Open(cfg.RollupPath, store.Current(), cfg.RollupRefresh)
+	if err != nil {
+		return err
+	}
+
+	store.OnSaleCreated(r.Add)
+	current = r
+	return nil
+}
+
+func Current() *Rollups {
+	return current
+}
+
+// Daily rollups for the caller's token, see Days
+func Fetch(c *fiber.Ctx, start string, end string) ([]*model.DailyRollup, error) {
+	return current.Days(c.UserContext(), c.Cookies("token"), start, end)
+}
+
+// Lifetime totals for the caller's token, see Lifetime
+func FetchLifetime(c *fiber.Ctx) (*model.DailyRollup, error) {
+	return current.Lifetime(c.UserContext(), c.Cookies("token"))
+}
+
+func Open(path string, s store.Store, refresh time.Duration) (*Rollups, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketDays, bucketMeta} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		// rollup files from before the lifetime totals were kept add their days up once
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyOpenDay) == nil || meta.Get(keyLifetime) != nil {
+			return nil
+		}
+		lifetime := helper.NewDailyRollup("")
+		err := tx.Bucket(bucketDays).ForEach(func(k, v []byte) error {
+			day := new(model.DailyRollup)
+			if err := json.Unmarshal(v, day); err != nil {
+				return err
+			}
+			helper.MergeRollup(lifetime, day)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return putLifetime(meta, lifetime)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Rollups{db: db, store: s, refresh: refresh}, nil
+}
+
+// Rollups between start and end (YYYY-MM-DD, both included, both empty for every day), oldest first.
+// When the store can't be reached, or another request is refreshing them, the last rollups are served as they are.
+func (r *Rollups) Days(ctx context.Context, token string, start string, end string) ([]*model.DailyRollup, error) {
+	if err := r.updateOrKeep(ctx, token); err != nil {
+		return nil, err
+	}
+
+	days := []*model.DailyRollup{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketDays).Cursor()
+		k, v := c.First()
+		if start != "" {
+			k, v = c.Seek([]byte(start))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if end != "" && string(k) > end {
+				break
+			}
+			day := new(model.DailyRollup)
+			if err := json.Unmarshal(v, day); err != nil {
+				return err
+			}
+			days = append(days, day)
+		}
+		return nil
+	})
+	return days, err
+}
+
+// Every day added up into one rollup (Date is left empty), the same as helper.MergeRollups over all the days
+// without reading them. Kept up to date the same way as the days.
+func (r *Rollups) Lifetime(ctx context.Context, token string) (*model.DailyRollup, error) {
+	if err := r.updateOrKeep(ctx, token); err != nil {
+		return nil, err
+	}
+
+	var lifetime *model.DailyRollup
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		lifetime, err = getLifetime(tx.Bucket(bucketMeta))
+		return err
+	})
+	return lifetime, err
+}
+
+// Throws every rollup away and rolls every sale up again
+func (r *Rollups) Rebuild(ctx context.Context, token string) error {
+	r.refreshing.Lock()
+	defer r.refreshing.Unlock()
+	return r.reroll(ctx, token, "", "")
+}
+
+// Adds a sale that was just created to its day, registered with store.OnSaleCreated.
+// The next refresh of the open day replaces this with what the store has, so nothing is counted twice for long.
+// A sale dated before the open day (an embedded store keeps the time an outbox sale was queued) isn't added to a
+// final day, the open day moves back to it instead so the next refresh rolls those days up again from the store.
+func (r *Rollups) Add(sale model.JsonSale) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.added++
+	openDay := r.openDay()
+	// nothing rolled up yet, the first Days call will pick the sale up
+	if openDay == "" {
+		return
+	}
+
+	date := sale.CreatedAt.In(time.Local).Format("2006-01-02")
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		if date < openDay {
+			return tx.Bucket(bucketMeta).Put(keyOpenDay, []byte(date))
+		}
+
+		b := tx.Bucket(bucketDays)
+		meta := tx.Bucket(bucketMeta)
+		lifetime, err := getLifetime(meta)
+		if err != nil {
+			return err
+		}
+		day := helper.NewDailyRollup(date)
+		if v := b.Get([]byte(date)); v != nil {
+			if err := json.Unmarshal(v, day); err != nil {
+				return err
+			}
+		}
+
+		helper.SubtractRollup(lifetime, day)
+		helper.AddSaleToRollup(day, sale)
+		helper.MergeRollup(lifetime, day)
+		if err := putDay(b, day); err != nil {
+			return err
+		}
+		return putLifetime(meta, lifetime)
+	})
+	if err != nil {
+		logger.Error("Error adding sale to rollup", "err", err)
+	}
+}
+
+func (r *Rollups) Close() error {
+	return r.db.Close()
+}
+
+// Updates the rollups, an error is only returned when there is nothing rolled up to fall back on.
+// Only waits for a refresh that's already running when there is nothing rolled up yet.
+func (r *Rollups) updateOrKeep(ctx context.Context, token string) error {
+	if r.openDay() == "" {
+		r.refreshing.Lock()
+	} else if !r.refreshing.TryLock() {
+		return nil
+	}
+	defer r.refreshing.Unlock()
+
+	if err := r.update(ctx, token); err != nil {
+		if r.openDay() == "" {
+			return err
+		}
+		logger.FromContext(ctx).Warn("Error updating rollups, using the last ones", "err", err)
+	}
+	return nil
+}
+
+// Rolls up everything when there is nothing yet, otherwise the days from the open day to today once refresh has passed
+func (r *Rollups) update(ctx context.Context, token string) error {
+	r.mu.Lock()
+	openDay := r.openDay()
+	refreshedAt := r.refreshedAt
+	r.mu.Unlock()
+
+	if openDay == "" {
+		return r.reroll(ctx, token, "", "")
+	}
+
+	today := time.Now().Format("2006-01-02")
+	if openDay == today && time.Since(refreshedAt) < r.refresh {
+		return nil
+	}
+	return r.reroll(ctx, token, openDay, today)
+}
+
+// Replaces the rollups between start and end with the store's sales for that range (both empty for everything).
+// The sales are fetched without holding mu so Add doesn't wait on the store. If the open day was moved back
+// meanwhile it stays where it is for the next refresh.
+func (r *Rollups) reroll(ctx context.Context, token string, start string, end string) error {
+	r.mu.Lock()
+	openDay := r.openDay()
+	added := r.added
+	r.mu.Unlock()
+
+	sales, err := r.store.FindSales(ctx, token, start, end)
+	if err != nil {
+		return err
+	}
+	days := helper.RollupSales(sales)
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		lifetime := helper.NewDailyRollup("")
+		if start == "" && end == "" {
+			if err := tx.DeleteBucket(bucketDays); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bucketDays); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if lifetime, err = getLifetime(meta); err != nil {
+				return err
+			}
+		}
+
+		b := tx.Bucket(bucketDays)
+		stale := [][]byte{}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(start)); k != nil && bytes.Compare(k, []byte(end)) <= 0; k, v = c.Next() {
+			day := new(model.DailyRollup)
+			if err := json.Unmarshal(v, day); err != nil {
+				return err
+			}
+			helper.SubtractRollup(lifetime, day)
+			stale = append(stale, append([]byte{}, k...))
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, day := range days {
+			helper.MergeRollup(lifetime, day)
+			if err := putDay(b, day); err != nil {
+				return err
+			}
+		}
+		if err := putLifetime(meta, lifetime); err != nil {
+			return err
+		}
+		if string(meta.Get(keyOpenDay)) != openDay {
+			return nil
+		}
+		return meta.Put(keyOpenDay, []byte(now.Format("2006-01-02")))
+	})
+	if err != nil {
+		return err
+	}
+
+	// a sale added while fetching may be missing from what the store sent
+	if r.added == added {
+		r.refreshedAt = now
+	}
+	if start == "" {
+		logger.FromContext(ctx).Info("Rolled up sales", "sales", len(sales), "days", len(days))
+	}
+	return nil
+}
+
+func (r *Rollups) openDay() string {
+	var openDay string
+	r.db.View(func(tx *bolt.Tx) error {
+		openDay = string(tx.Bucket(bucketMeta).Get(keyOpenDay))
+		return nil
+	})
+	return openDay
+}
+
+func putDay(b *bolt.Bucket, day *model.DailyRollup) error {
+	v, err := json.Marshal(day)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(day.Date), v)
+}
+
+func getLifetime(meta *bolt.Bucket) (*model.DailyRollup, error) {
+	lifetime := helper.NewDailyRollup("")
+	if v := meta.Get(keyLifetime); v != nil {
+		if err := json.Unmarshal(v, lifetime); err != nil {
+			return nil, err
+		}
+	}
+	return lifetime, nil
+}
+
+func putLifetime(meta *bolt.Bucket, lifetime *model.DailyRollup) error {
+	v, err := json.Marshal(lifetime)
+	if err != nil {
+		return err
+	}
+	return meta.Put(keyLifetime, v)
+}
@@ -0,0 +1,247 @@
+package rollup
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/helper"
+	"github.com/CRTOsp3ck/mims-app/model"
+	"github.com/CRTOsp3ck/mims-app/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Only FindSales is called by the rollups
+type fakeStore struct {
+	store.Store
+	sales []model.JsonSale
+	// when set FindSales says it started on fetching and waits for release
+	fetching chan struct{}
+	release  chan struct{}
+}
+
+func (s *fakeStore) FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error) {
+	if s.fetching != nil {
+		s.fetching <- struct{}{}
+		<-s.release
+	}
+	found := []model.JsonSale{}
+	for _, sale := range s.sales {
+		date := sale.CreatedAt.Local().Format("2006-01-02")
+		if (start == "" && end == "") || (date >= start && date <= end) {
+			found = append(found, sale)
+		}
+	}
+	return found, nil
+}
+
+func sale(at time.Time, amount float32) model.JsonSale {
+	return model.JsonSale{Amount: amount, Qty: 1, PaymentType: 1, OperationID: 1, ItemID: 1, CreatedAt: at}
+}
+
+func TestAdd(t *testing.T) {
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+	queuedAt := now.AddDate(0, 0, -1)
+
+	tests := []struct {
+		name string
+		// in the store before the first roll up
+		before []model.JsonSale
+		// the sale handed to Add, and the one the store ended up with
+		added  model.JsonSale
+		stored model.JsonSale
+		// right after Add
+		wantOpenDay string
+		wantAdded   map[string]float64
+		// once the open day is rolled up again
+		wantRolled map[string]float64
+	}{
+		{
+			name:        "sale made today",
+			before:      []model.JsonSale{sale(now, 8)},
+			added:       sale(now, 8),
+			stored:      sale(now, 8),
+			wantOpenDay: today,
+			wantAdded:   map[string]float64{today: 16},
+			wantRolled:  map[string]float64{today: 16},
+		},
+		{
+			// the http store stamps the time it was synced
+			name:        "queued sale synced today",
+			before:      []model.JsonSale{sale(queuedAt, 8)},
+			added:       sale(now, 8),
+			stored:      sale(now, 8),
+			wantOpenDay: today,
+			wantAdded:   map[string]float64{yesterday: 8, today: 8},
+			wantRolled:  map[string]float64{yesterday: 8, today: 8},
+		},
+		{
+			// the embedded store keeps the time it was queued, the final day is rolled up again
+			name:        "queued sale dated before the open day",
+			before:      []model.JsonSale{sale(queuedAt, 8)},
+			added:       sale(queuedAt, 8),
+			stored:      sale(queuedAt, 8),
+			wantOpenDay: yesterday,
+			wantAdded:   map[string]float64{yesterday: 8},
+			wantRolled:  map[string]float64{yesterday: 16},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &fakeStore{sales: tt.before}
+			r, err := Open(filepath.Join(t.TempDir(), "rollup.db"), s, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			if _, err := r.Days(context.Background(), "", "", ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.openDay(); got != today {
+				t.Fatalf("open day after the first roll up = %s, want %s", got, today)
+			}
+
+			s.sales = append(s.sales, tt.stored)
+			r.Add(tt.added)
+			if got := r.openDay(); got != tt.wantOpenDay {
+				t.Errorf("open day after Add = %s, want %s", got, tt.wantOpenDay)
+			}
+			checkTotals(t, r, "after Add", tt.wantAdded)
+
+			if _, err := r.Days(context.Background(), "", "", ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := r.openDay(); got != today {
+				t.Errorf("open day after the refresh = %s, want %s", got, today)
+			}
+			checkTotals(t, r, "after the refresh", tt.wantRolled)
+		})
+	}
+}
+
+// Compares the amount of every stored day with want and the lifetime totals with the days added up,
+// without rolling anything up
+func checkTotals(t *testing.T, r *Rollups, when string, want map[string]float64) {
+	t.Helper()
+	got := map[string]float64{}
+	days := []*model.DailyRollup{}
+	var lifetime *model.DailyRollup
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		if lifetime, err = getLifetime(tx.Bucket(bucketMeta)); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketDays).ForEach(func(k, v []byte) error {
+			day := new(model.DailyRollup)
+			if err := json.Unmarshal(v, day); err != nil {
+				return err
+			}
+			got[day.Date] = day.Total.Amount
+			days = append(days, day)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := helper.MergeRollups(days)
+	if lifetime.Total != merged.Total || !reflect.DeepEqual(lifetime.Items, merged.Items) || !reflect.DeepEqual(lifetime.Payments, merged.Payments) {
+		t.Errorf("%s: lifetime = %+v, want the days added up %+v", when, lifetime.Total, merged.Total)
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s: days = %v, want %v", when, got, want)
+		return
+	}
+	for date, amount := range want {
+		if got[date] != amount {
+			t.Errorf("%s: %s = %v, want %v", when, date, got[date], amount)
+		}
+	}
+}
+
+func TestLifetimeFromOldFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rollup.db")
+	now := time.Now()
+	s := &fakeStore{sales: []model.JsonSale{sale(now.AddDate(0, 0, -2), 5), sale(now, 8)}}
+	r, err := Open(path, s, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Days(context.Background(), "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// a rollup file written before the lifetime totals were kept
+	err = r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketMeta).Delete(keyLifetime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	r, err = Open(path, s, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	lifetime, err := r.Lifetime(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime.Total.Amount != 13 || lifetime.Total.Count != 2 {
+		t.Errorf("lifetime = %+v, want 2 sales for 13", lifetime.Total)
+	}
+}
+
+func TestRefreshDoesNotBlock(t *testing.T) {
+	now := time.Now()
+	s := &fakeStore{sales: []model.JsonSale{sale(now, 8)}}
+	r, err := Open(filepath.Join(t.TempDir(), "rollup.db"), s, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Days(context.Background(), "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	r.refreshedAt = time.Time{}
+	s.fetching = make(chan struct{})
+	s.release = make(chan struct{})
+	refreshed := make(chan error)
+	go func() {
+		_, err := r.Days(context.Background(), "", "", "")
+		refreshed <- err
+	}()
+	<-s.fetching
+
+	// neither a sale nor another report waits for the store
+	done := make(chan struct{})
+	go func() {
+		r.Add(sale(now, 8))
+		if _, err := r.Days(context.Background(), "", "", ""); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Add and Days waited for the refresh fetching sales")
+	}
+
+	close(s.release)
+	if err := <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+	if !r.refreshedAt.IsZero() {
+		t.Error("refresh that overlapped an Add counted as fresh")
+	}
+}
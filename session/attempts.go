@@ -0,0 +1,95 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Login attempts, oldest first under sequential keys. Dropped after LOGIN_ATTEMPT_RETENTION.
+var bucketAttempts = []byte("login_attempts")
+
+func (s *Sessions) RecordAttempt(a *model.LoginAttempt) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketAttempts)
+
+		if s.attemptRetention > 0 {
+			// the oldest are first, stop at the first one still kept
+			cutoff := time.Now().Add(-s.attemptRetention)
+			expired := [][]byte{}
+			c := b.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				old := new(model.LoginAttempt)
+				if json.Unmarshal(v, old) == nil && old.Time.After(cutoff) {
+					break
+				}
+				expired = append(expired, append([]byte{}, k...))
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		return b.Put(itob(id), v)
+	})
+}
+
+// Up to n attempts, newest first
+func (s *Sessions) RecentAttempts(n int) ([]*model.LoginAttempt, error) {
+	attempts := []*model.LoginAttempt{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAttempts).Cursor()
+		for k, v := c.Last(); k != nil && len(attempts) < n; k, v = c.Prev() {
+			a := new(model.LoginAttempt)
+			if err := json.Unmarshal(v, a); err != nil {
+				return err
+			}
+			attempts = append(attempts, a)
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+// identity's attempts since since, oldest first
+func (s *Sessions) IdentityAttempts(identity string, since time.Time) ([]*model.LoginAttempt, error) {
+	attempts := []*model.LoginAttempt{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketAttempts).Cursor()
+		// walked back from the newest, so only the window is read
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			a := new(model.LoginAttempt)
+			if err := json.Unmarshal(v, a); err != nil {
+				return err
+			}
+			if a.Time.Before(since) {
+				break
+			}
+			if a.Identity == identity {
+				attempts = append([]*model.LoginAttempt{a}, attempts...)
+			}
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+// big endian so the keys sort in order
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
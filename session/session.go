@@ -0,0 +1,355 @@
+// Package session remembers who is behind each login token (identity and role) in a BoltDB file,
+// so the role travels with the token cookie without asking the store on every request.
+// Tokens are only kept hashed. "Remember me" logins live here too, keyed by their own (hashed)
+// one-time refresh token, each use hands out a new one, and so does the log of login attempts.
+// A remembered login needs the store's token back to log in again, it's kept encrypted with a key
+// that comes from the refresh token, so it can't be read without the browser's cookie. Identities,
+// roles, expiry times and the login attempts (identity, IP address, result) are kept as they are.
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketSessions = []byte("sessions")
+	bucketRemember = []byte("remember")
+)
+
+type Sessions struct {
+	db *bolt.DB
+	// how long a login lasts, and a "remember me" one
+	length         time.Duration
+	rememberLength time.Duration
+	// how long login attempts are kept
+	attemptRetention time.Duration
+}
+
+var current *Sessions
+
+// Opens the sessions at SESSION_PATH, call once at startup
+func Init(cfg *config.Config) error {
+	rememberLength := cfg.RememberMeLength
+	// a remembered login is no good once the datastore's token behind it has expired
+	if cfg.Store == "http" && cfg.Datastore.TokenLength > 0 && cfg.Datastore.TokenLength < rememberLength {
+		logger.Warn("REMEMBER_ME_LENGTH is longer than the datastore's tokens last, using DATASTORE_TOKEN_LENGTH",
+			"remember_me_length", rememberLength, "token_length", cfg.Datastore.TokenLength)
+		rememberLength = cfg.Datastore.TokenLength
+	}
+
+	s, err := Open(cfg.SessionPath, cfg.SessionLength, rememberLength)
+	if err != nil {
+		return err
+	}
+	s.attemptRetention = cfg.Login.AttemptRetention
+	current = s
+	return nil
+}
+
+func Current() *Sessions {
+	return current
+}
+
+func Open(path string, length time.Duration, rememberLength time.Duration) (*Sessions, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketSessions, bucketRemember, bucketAttempts} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Sessions{db: db, length: length, rememberLength: rememberLength}, nil
+}
+
+// Starts a session for token, expired ones are dropped on the way
+func (s *Sessions) Create(token string, identity string, role string, mustChangePassword bool) (*model.Session, error) {
+	now := time.Now()
+	sess := &model.Session{Identity: identity, Role: role, CreatedAt: now, Expires: now.Add(s.length), MustChangePassword: mustChangePassword}
+
+	v, err := json.Marshal(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		if err := pruneExpired(b, now); err != nil {
+			return err
+		}
+		return b.Put(key(token), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sess, nil
+}
+
+// The session behind token, nil when there is none or it has expired
+func (s *Sessions) Get(token string) (*model.Session, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var sess *model.Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketSessions).Get(key(token))
+		if v == nil {
+			return nil
+		}
+		sess = new(model.Session)
+		return json.Unmarshal(v, sess)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || time.Now().After(sess.Expires) {
+		return nil, nil
+	}
+	return sess, nil
+}
+
+// Lifts the forced password change once it's done
+func (s *Sessions) PasswordChanged(token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketSessions)
+		v := b.Get(key(token))
+		if v == nil {
+			return nil
+		}
+		sess := new(model.Session)
+		if err := json.Unmarshal(v, sess); err != nil {
+			return err
+		}
+		sess.MustChangePassword = false
+		v, err := json.Marshal(sess)
+		if err != nil {
+			return err
+		}
+		return b.Put(key(token), v)
+	})
+}
+
+func (s *Sessions) Delete(token string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketSessions).Delete(key(token))
+	})
+}
+
+// Logs identity out everywhere, remembered logins included, for disabled accounts and password resets
+func (s *Sessions) DeleteIdentity(identity string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := deleteWhere(tx.Bucket(bucketSessions), func(v []byte) bool {
+			sess := new(model.Session)
+			return json.Unmarshal(v, sess) == nil && sess.Identity == identity
+		})
+		if err != nil {
+			return err
+		}
+		return deleteWhere(tx.Bucket(bucketRemember), func(v []byte) bool {
+			r := new(model.RememberMe)
+			return json.Unmarshal(v, r) == nil && r.Identity == identity
+		})
+	})
+}
+
+// Remembers token's login, the returned refresh token goes in the "remember me" cookie
+func (s *Sessions) Remember(token string, identity string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	refresh := hex.EncodeToString(b)
+
+	sealed, err := seal(refresh, token)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	v, err := json.Marshal(&model.RememberMe{Identity: identity, Token: sealed, Expires: now.Add(s.rememberLength)})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketRemember)
+		err := deleteWhere(b, func(v []byte) bool {
+			r := new(model.RememberMe)
+			return json.Unmarshal(v, r) != nil || now.After(r.Expires)
+		})
+		if err != nil {
+			return err
+		}
+		return b.Put(key(refresh), v)
+	})
+	if err != nil {
+		return "", err
+	}
+	return refresh, nil
+}
+
+// The login behind refresh, nil when there is none or it has expired. Either way refresh
+// can't be used again, call Remember for the next one.
+func (s *Sessions) TakeRemembered(refresh string) (*model.RememberMe, error) {
+	if refresh == "" {
+		return nil, nil
+	}
+
+	var r *model.RememberMe
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketRemember)
+		v := b.Get(key(refresh))
+		if v == nil {
+			return nil
+		}
+		r = new(model.RememberMe)
+		if err := json.Unmarshal(v, r); err != nil {
+			return err
+		}
+		return b.Delete(key(refresh))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if r == nil || time.Now().After(r.Expires) {
+		return nil, nil
+	}
+	// from before tokens were sealed, that login has to be made again
+	if r.Token, err = unseal(refresh, r.Token); err != nil {
+		logger.Warn("Unable to read remembered login, dropped", "identity", r.Identity, "err", err)
+		return nil, nil
+	}
+	return r, nil
+}
+
+// Drops a remembered login, on logout
+func (s *Sessions) Forget(refresh string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRemember).Delete(key(refresh))
+	})
+}
+
+// How long a "remember me" cookie lasts
+func (s *Sessions) RememberLength() time.Duration {
+	return s.rememberLength
+}
+
+func (s *Sessions) Close() error {
+	return s.db.Close()
+}
+
+func pruneExpired(b *bolt.Bucket, now time.Time) error {
+	count := 0
+	err := deleteWhere(b, func(v []byte) bool {
+		sess := new(model.Session)
+		expired := json.Unmarshal(v, sess) != nil || now.After(sess.Expires)
+		if expired {
+			count++
+		}
+		return expired
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		logger.Debug("Dropped expired sessions", "count", count)
+	}
+	return nil
+}
+
+// Deletes the entries of b whose value matches
+func deleteWhere(b *bolt.Bucket, match func(v []byte) bool) error {
+	keys := [][]byte{}
+	err := b.ForEach(func(k, v []byte) error {
+		if match(v) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encrypts a remembered store token with a key only refresh gives (the db keeps key(refresh), not refresh)
+func seal(refresh string, token string) (string, error) {
+	gcm, err := rememberCipher(refresh)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+func unseal(refresh string, sealed string) (string, error) {
+	gcm, err := rememberCipher(refresh)
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(b) < gcm.NonceSize() {
+		return "", errors.New("sealed token too short")
+	}
+	token, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+func rememberCipher(refresh string) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, []byte(refresh))
+	mac.Write([]byte("mims remember me token"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func key(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return []byte(hex.EncodeToString(sum[:]))
+}
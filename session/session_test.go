@@ -0,0 +1,141 @@
+package session
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, length time.Duration, rememberLength time.Duration) (*Sessions, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := Open(path, length, rememberLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func TestSessions(t *testing.T) {
+	tests := []struct {
+		name   string
+		length time.Duration
+		// after Create
+		prepare func(s *Sessions) error
+		token   string
+		want    bool
+	}{
+		{"created", time.Hour, nil, "tok-ann", true},
+		{"other token", time.Hour, nil, "tok-bob", false},
+		{"no token", time.Hour, nil, "", false},
+		{"expired", -time.Second, nil, "tok-ann", false},
+		{"logged out", time.Hour, func(s *Sessions) error { return s.Delete("tok-ann") }, "tok-ann", false},
+		{"account disabled", time.Hour, func(s *Sessions) error { return s.DeleteIdentity("ann") }, "tok-ann", false},
+		{"someone else disabled", time.Hour, func(s *Sessions) error { return s.DeleteIdentity("bob") }, "tok-ann", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := open(t, tt.length, time.Hour)
+			if _, err := s.Create("tok-ann", "ann", "cashier", false); err != nil {
+				t.Fatal(err)
+			}
+			if tt.prepare != nil {
+				if err := tt.prepare(s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sess, err := s.Get(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (sess != nil) != tt.want {
+				t.Fatalf("Get(%q) = %+v, want a session %v", tt.token, sess, tt.want)
+			}
+			if sess != nil && (sess.Identity != "ann" || sess.Role != "cashier") {
+				t.Errorf("session = %+v, want ann the cashier", sess)
+			}
+		})
+	}
+}
+
+func TestRemember(t *testing.T) {
+	const token = "datastore-bearer-token-1234"
+
+	tests := []struct {
+		name           string
+		rememberLength time.Duration
+		// the refresh token to take, given the one Remember handed out
+		refresh func(refresh string) string
+		forget  bool
+		want    bool
+	}{
+		{"remembered", time.Hour, func(r string) string { return r }, false, true},
+		{"unknown refresh token", time.Hour, func(r string) string { return r[:len(r)-1] + "x" }, false, false},
+		{"empty", time.Hour, func(r string) string { return "" }, false, false},
+		{"expired", -time.Second, func(r string) string { return r }, false, false},
+		{"logged out", time.Hour, func(r string) string { return r }, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, path := open(t, time.Hour, tt.rememberLength)
+			refresh, err := s.Remember(token, "ann")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.forget {
+				if err := s.Forget(refresh); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := s.TakeRemembered(tt.refresh(refresh))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (r != nil) != tt.want {
+				t.Fatalf("TakeRemembered = %+v, want a login %v", r, tt.want)
+			}
+			if r == nil {
+				return
+			}
+			if r.Identity != "ann" || r.Token != token {
+				t.Errorf("remembered = %+v, want ann with the store's token", r)
+			}
+			// one use only
+			if again, _ := s.TakeRemembered(refresh); again != nil {
+				t.Error("refresh token worked twice")
+			}
+
+			// neither the store's token nor the refresh token is in the file
+			s.Close()
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{token, refresh} {
+				if bytes.Contains(b, []byte(secret)) {
+					t.Errorf("%q is in the session file", secret)
+				}
+			}
+		})
+	}
+}
+
+func TestSeal(t *testing.T) {
+	sealed, err := seal("refresh-a", "tok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := unseal("refresh-a", sealed); err != nil || got != "tok" {
+		t.Errorf("unseal = %q, %v, want tok", got, err)
+	}
+	// another refresh token, or a record from before tokens were sealed
+	for _, tt := range []struct{ refresh, sealed string }{{"refresh-b", sealed}, {"refresh-a", "tok"}, {"refresh-a", ""}} {
+		if got, err := unseal(tt.refresh, tt.sealed); err == nil {
+			t.Errorf("unseal(%q, %q) = %q, want an error", tt.refresh, tt.sealed, got)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+var _ Store = (*cachedStore)(nil)
+
+// Keeps FindSales results for a while, the reports ask for the same ranges over and over.
+// Results are keyed by range only, every user of the app sees the same sales and auth is
+// checked before anything gets here.
+type cachedStore struct {
+	Store
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[[2]string]cachedSales
+}
+
+type cachedSales struct {
+	sales   []model.JsonSale
+	expires time.Time
+}
+
+func newCachedStore(s Store, ttl time.Duration) *cachedStore {
+	return &cachedStore{Store: s, ttl: ttl, entries: map[[2]string]cachedSales{}}
+}
+
+func (s *cachedStore) FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error) {
+	key := [2]string{start, end}
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.sales, nil
+	}
+
+	sales, err := s.Store.FindSales(ctx, token, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.entries[key] = cachedSales{sales: sales, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return sales, nil
+}
+
+func (s *cachedStore) CreateSale(ctx context.Context, token string, sale *model.JsonSale) error {
+	err := s.Store.CreateSale(ctx, token, sale)
+
+	// even a failed post may have landed (timeout, 5xx), so drop what could be missing it either way
+	s.invalidate(time.Now().Format("2006-01-02"))
+	return err
+}
+
+// Drops every cached range that includes date, sales are only ever added today so older ranges stay
+func (s *cachedStore) invalidate(date string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.entries {
+		if (key[0] == "" && key[1] == "") || (date >= key[0] && date <= key[1]) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	bucketUsers    = []byte("users")
+	bucketSessions = []byte("sessions")
+	bucketSales    = []byte("sales")
+	bucketTargets  = []byte("targets")
+)
+
+var _ Store = (*EmbeddedStore)(nil)
+
+// Everything in one BoltDB file, for running the stall on a single laptop or developing without the datastore
+type EmbeddedStore struct {
+	db *bolt.DB
+	// how long a login token is good for, the app's own session decides how long it's used
+	tokenLength time.Duration
+}
+
+type embeddedUser struct {
+	Identity     string `json:"identity"`
+	PasswordHash []byte `json:"password_hash"`
+	// empty for users from before roles, they were all admins
+	Role               string    `json:"role,omitempty"`
+	Disabled           bool      `json:"disabled,omitempty"`
+	MustChangePassword bool      `json:"must_change_password,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	CreatedBy          string    `json:"created_by,omitempty"`
+}
+
+func (u *embeddedUser) user() *model.User {
+	role := u.Role
+	if role == "" {
+		role = model.RoleOwner
+	}
+	return &model.User{
+		Identity:           u.Identity,
+		Role:               role,
+		Disabled:           u.Disabled,
+		MustChangePassword: u.MustChangePassword,
+		CreatedAt:          u.CreatedAt,
+		CreatedBy:          u.CreatedBy,
+	}
+}
+
+type embeddedSession struct {
+	Identity string    `json:"identity"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Opens (or creates) the db at path. When there are no users yet and an admin identity/password is given, that user is created.
+func NewEmbeddedStore(path string, tokenLength time.Duration, adminIdentity string, adminPassword string) (*EmbeddedStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &EmbeddedStore{db: db, tokenLength: tokenLength}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketUsers, bucketSessions, bucketSales, bucketTargets} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := s.seedAdmin(adminIdentity, adminPassword); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *EmbeddedStore) seedAdmin(identity string, password string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		if users.Stats().KeyN > 0 {
+			return nil
+		}
+		if identity == "" || password == "" {
+			logger.Warn("Embedded store has no users, set EMBEDDED_ADMIN_IDENTITY and EMBEDDED_ADMIN_PASSWORD to create one")
+			return nil
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		return putJSON(users, []byte(identity), embeddedUser{Identity: identity, PasswordHash: hash, Role: model.RoleOwner, CreatedAt: time.Now()})
+	})
+}
+
+func (s *EmbeddedStore) Login(ctx context.Context, identity string, password string) (string, error) {
+	var token string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var user embeddedUser
+		if !getJSON(tx.Bucket(bucketUsers), []byte(identity), &user) {
+			return ErrInvalidCredentials
+		}
+		if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+			return ErrInvalidCredentials
+		}
+		if user.Disabled {
+			return ErrUserDisabled
+		}
+
+		var err error
+		token, err = s.newToken(tx, identity)
+		return err
+	})
+	return token, err
+}
+
+func (s *EmbeddedStore) RenewToken(ctx context.Context, token string) (string, error) {
+	var renewed string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var session embeddedSession
+		if token == "" || !getJSON(tx.Bucket(bucketSessions), []byte(token), &session) || !time.Now().Before(session.Expires) {
+			return ErrInvalidCredentials
+		}
+		var user embeddedUser
+		if !getJSON(tx.Bucket(bucketUsers), []byte(session.Identity), &user) {
+			return ErrInvalidCredentials
+		}
+		if user.Disabled {
+			return ErrUserDisabled
+		}
+
+		var err error
+		if renewed, err = s.newToken(tx, session.Identity); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketSessions).Delete([]byte(token))
+	})
+	return renewed, err
+}
+
+// Stores a new token for identity, good for tokenLength
+func (s *EmbeddedStore) newToken(tx *bolt.Tx, identity string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	err := putJSON(tx.Bucket(bucketSessions), []byte(token), embeddedSession{
+		Identity: identity,
+		Expires:  time.Now().Add(s.tokenLength),
+	})
+	return token, err
+}
+
+func (s *EmbeddedStore) CheckAuth(ctx context.Context, token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	var session embeddedSession
+	var user embeddedUser
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if !getJSON(tx.Bucket(bucketSessions), []byte(token), &session) {
+			session = embeddedSession{}
+		}
+		if session.Identity != "" && !getJSON(tx.Bucket(bucketUsers), []byte(session.Identity), &user) {
+			// deleted since
+			session = embeddedSession{}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return session.Identity != "" && !user.Disabled && time.Now().Before(session.Expires), nil
+}
+
+func (s *EmbeddedStore) User(identity string) (*model.User, error) {
+	var user embeddedUser
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if !getJSON(tx.Bucket(bucketUsers), []byte(identity), &user) {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user.user(), nil
+}
+
+func (s *EmbeddedStore) ListUsers() ([]*model.User, error) {
+	users := []*model.User{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys are identities, so this comes out sorted
+		return tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+			var user embeddedUser
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+			users = append(users, user.user())
+			return nil
+		})
+	})
+	return users, err
+}
+
+func (s *EmbeddedStore) CreateUser(ctx context.Context, token string, by string, user *model.User, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		if users.Get([]byte(user.Identity)) != nil {
+			return ErrUserExists
+		}
+		user.MustChangePassword = true
+		user.CreatedAt = time.Now()
+		user.CreatedBy = by
+		return putJSON(users, []byte(user.Identity), embeddedUser{
+			Identity:           user.Identity,
+			PasswordHash:       hash,
+			Role:               user.Role,
+			MustChangePassword: true,
+			CreatedAt:          user.CreatedAt,
+			CreatedBy:          user.CreatedBy,
+		})
+	})
+}
+
+func (s *EmbeddedStore) SetUserDisabled(ctx context.Context, token string, by string, identity string, disabled bool) error {
+	return s.updateUser(identity, func(user *embeddedUser) error {
+		user.Disabled = disabled
+		return nil
+	})
+}
+
+func (s *EmbeddedStore) ResetPassword(ctx context.Context, token string, by string, identity string, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(identity, func(user *embeddedUser) error {
+		user.PasswordHash = hash
+		user.MustChangePassword = true
+		return nil
+	})
+}
+
+func (s *EmbeddedStore) ChangePassword(ctx context.Context, token string, identity string, current string, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.updateUser(identity, func(user *embeddedUser) error {
+		if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(current)) != nil {
+			return ErrInvalidCredentials
+		}
+		user.PasswordHash = hash
+		user.MustChangePassword = false
+		return nil
+	})
+}
+
+func (s *EmbeddedStore) updateUser(identity string, fn func(user *embeddedUser) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		var user embeddedUser
+		if !getJSON(users, []byte(identity), &user) {
+			return ErrUserNotFound
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+		return putJSON(users, []byte(identity), user)
+	})
+}
+
+func (s *EmbeddedStore) CreateSale(ctx context.Context, token string, sale *model.JsonSale) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		sales := tx.Bucket(bucketSales)
+		id, err := sales.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		sale.ID = int(id)
+		if sale.CreatedAt.IsZero() {
+			sale.CreatedAt = now
+		}
+		sale.UpdatedAt = now
+		return putJSON(sales, itob(id), sale)
+	})
+}
+
+func (s *EmbeddedStore) FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error) {
+	sales := []model.JsonSale{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys are sequential ids, so this comes out oldest first like the datastore
+		return tx.Bucket(bucketSales).ForEach(func(k, v []byte) error {
+			var sale model.JsonSale
+			if err := json.Unmarshal(v, &sale); err != nil {
+				return err
+			}
+			if inRange(sale, start, end) {
+				sales = append(sales, sale)
+			}
+			return nil
+		})
+	})
+	return sales, err
+}
+
+func (s *EmbeddedStore) ListTargets() ([]*model.Target, error) {
+	targets := []*model.Target{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTargets).ForEach(func(k, v []byte) error {
+			t := new(model.Target)
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			targets = append(targets, t)
+			return nil
+		})
+	})
+	return targets, err
+}
+
+func (s *EmbeddedStore) CreateTarget(t *model.Target) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		targets := tx.Bucket(bucketTargets)
+		id, err := targets.NextSequence()
+		if err != nil {
+			return err
+		}
+		t.ID = int(id)
+		return putJSON(targets, itob(id), t)
+	})
+}
+
+func (s *EmbeddedStore) DeleteTarget(id int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketTargets).Delete(itob(uint64(id)))
+	})
+}
+
+// The db is on this machine, it's there as long as the app is
+func (s *EmbeddedStore) Health() Health {
+	return Health{Kind: "embedded", Available: true, Breaker: BreakerClosed}
+}
+
+func (s *EmbeddedStore) Ping(ctx context.Context) error {
+	return s.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *EmbeddedStore) Close() error {
+	return s.db.Close()
+}
+
+// big endian so the keys sort in id order
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func putJSON(b *bolt.Bucket, key []byte, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, buf)
+}
+
+// false when the key isnt there
+func getJSON(b *bolt.Bucket, key []byte, v interface{}) bool {
+	buf := b.Get(key)
+	if buf == nil {
+		return false
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		logger.Error("Error unmarshalling embedded store value", "key", string(key), "err", err)
+		return false
+	}
+	return true
+}
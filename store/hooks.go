@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+var _ Store = (*hookedStore)(nil)
+
+var (
+	hooksMu sync.Mutex
+	onSale  []func(sale model.JsonSale)
+)
+
+// Registers fn to be called with every sale created through the store (directly or from the outbox)
+func OnSaleCreated(fn func(sale model.JsonSale)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	onSale = append(onSale, fn)
+}
+
+// Calls the OnSaleCreated hooks (the rollups behind the lifetime and periodic totals, the sales metrics)
+// after every sale the store takes.
+// Always in front of the store, whether the sales cache is on or not.
+type hookedStore struct {
+	Store
+}
+
+func newHookedStore(s Store) *hookedStore {
+	return &hookedStore{Store: s}
+}
+
+func (s *hookedStore) CreateSale(ctx context.Context, token string, sale *model.JsonSale) error {
+	if err := s.Store.CreateSale(ctx, token, sale); err != nil {
+		return err
+	}
+
+	created := *sale
+	if created.CreatedAt.IsZero() {
+		created.CreatedAt = time.Now()
+	}
+	hooksMu.Lock()
+	hooks := onSale
+	hooksMu.Unlock()
+	for _, fn := range hooks {
+		fn(created)
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/metrics"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+var _ Store = (*HTTPStore)(nil)
+
+// Sent to the datastore with every call, so its logs can be matched with ours
+const RequestIDHeader = "X-Request-ID"
+
+var (
+	datastoreDuration = metrics.NewHistogram("mims_datastore_call_duration_seconds",
+		"Datastore calls (each try) by endpoint.", metrics.DefaultBuckets, "endpoint")
+	datastoreErrors = metrics.NewCounter("mims_datastore_call_errors_total",
+		"Datastore calls that failed by endpoint and reason (network, server, circuit_open).", "endpoint", "reason")
+	authChecks = metrics.NewCounter("mims_auth_checks_total",
+		"Auth checks by where the answer came from (cache, datastore, grace while it was down, error).", "result")
+)
+
+// One kind of datastore call
+type endpoint struct {
+	// for the metrics
+	name    string
+	timeout time.Duration
+	// safe to send again when it fails (GETs), others go once
+	retry bool
+}
+
+// Talks to mims-datastore, follow the api specification from there
+type HTTPStore struct {
+	targetsFile
+	usersFile
+	addr    string
+	client  http.Client
+	breaker *breaker
+
+	login      endpoint
+	authStatus endpoint
+	findSales  endpoint
+	newSale    endpoint
+	users      endpoint
+	ping       endpoint
+	retries    int
+	retryDelay time.Duration
+
+	// tokens the datastore said yes to, and when
+	authMu    sync.Mutex
+	authSeen  map[string]time.Time
+	authTTL   time.Duration
+	authGrace time.Duration
+}
+
+func NewHTTPStore(cfg *config.Config) *HTTPStore {
+	ds := cfg.Datastore
+
+	return &HTTPStore{
+		targetsFile: targetsFile{path: cfg.TargetsFile},
+		usersFile:   usersFile{path: cfg.RolesFile},
+		addr:        cfg.APIServerAddr,
+		breaker:     newBreaker(ds.BreakerFailures, ds.BreakerCooldown),
+
+		login:      endpoint{name: "login", timeout: ds.TimeoutLogin},
+		authStatus: endpoint{name: "auth_status", timeout: ds.TimeoutAuth, retry: true},
+		findSales:  endpoint{name: "find_sales", timeout: ds.TimeoutFind, retry: true},
+		newSale:    endpoint{name: "new_sale", timeout: ds.TimeoutSale},
+		users:      endpoint{name: "users", timeout: ds.TimeoutLogin},
+		ping:       endpoint{name: "ping", timeout: ds.TimeoutAuth},
+		retries:    ds.Retries,
+		retryDelay: ds.RetryDelay,
+
+		authSeen:  map[string]time.Time{},
+		authTTL:   ds.AuthCache,
+		authGrace: ds.AuthGrace,
+	}
+}
+
+// Sends the request, retrying retryable endpoints on network errors and 5xx. Unavailability comes back as *UnavailableError.
+func (s *HTTPStore) do(ctx context.Context, ep endpoint, method string, path string, token string, body []byte) ([]byte, int, error) {
+	attempts := 1
+	if ep.retry {
+		attempts += s.retries
+	}
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		// a caller that gave up gets the last failure instead of waiting out the backoff
+		if i > 0 && !s.wait(ctx, s.backoff(i)) {
+			return nil, 0, err
+		}
+		if !s.breaker.Allow() {
+			datastoreErrors.Inc(ep.name, "circuit_open")
+			return nil, 0, &UnavailableError{Err: ErrCircuitOpen}
+		}
+
+		var b []byte
+		var status int
+		b, status, err = s.send(ctx, ep, method, path, token, body, i+1)
+
+		var unavailable *UnavailableError
+		if errors.As(err, &unavailable) {
+			s.breaker.Failure()
+			continue
+		}
+		s.breaker.Success()
+		return b, status, err
+	}
+	return nil, 0, err
+}
+
+// One try at the request, logged with its path, status and how long it took
+func (s *HTTPStore) send(ctx context.Context, ep endpoint, method string, path string, token string, body []byte, attempt int) (b []byte, status int, err error) {
+	log := logger.FromContext(ctx)
+	started := time.Now()
+	defer func() {
+		datastoreDuration.Observe(time.Since(started).Seconds(), ep.name)
+		fields := []interface{}{"method", method, "path", path, "status", status, "duration_ms", logger.Millis(started), "attempt", attempt}
+		if err != nil {
+			reason := "network"
+			if status >= 500 {
+				reason = "server"
+			}
+			datastoreErrors.Inc(ep.name, reason)
+			log.Warn("Datastore call failed", append(fields, "err", err)...)
+		} else {
+			log.Info("Datastore call", fields...)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, ep.timeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.addr+path, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if token != "" {
+		// add authorization header to the req
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "mims-app")
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		// a failed dial never reached the datastore, anything after that might have
+		var opErr *net.OpError
+		sent := !(errors.As(err, &opErr) && opErr.Op == "dial")
+		return nil, 0, &UnavailableError{Err: err, Sent: sent}
+	}
+	defer res.Body.Close()
+
+	status = res.StatusCode
+	b, err = io.ReadAll(res.Body)
+	if err != nil {
+		return nil, res.StatusCode, &UnavailableError{Err: err, Sent: true}
+	}
+	if res.StatusCode >= 500 {
+		return nil, res.StatusCode, &UnavailableError{Err: fmt.Errorf("datastore returned %d", res.StatusCode), Sent: true}
+	}
+	return b, res.StatusCode, nil
+}
+
+// Waits for d, false when ctx is done first
+func (s *HTTPStore) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Doubles retryDelay each retry, with full jitter between half and one and a half times that
+func (s *HTTPStore) backoff(retry int) time.Duration {
+	d := s.retryDelay << (retry - 1)
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
+}
+
+func (s *HTTPStore) Login(ctx context.Context, identity string, password string) (string, error) {
+	bytesObj := []byte(fmt.Sprintf(`{
+			"identity": %q,
+			"password": %q
+		}`, identity, password))
+
+	b, status, err := s.do(ctx, s.login, http.MethodPost, "/auth/login", "", bytesObj)
+	if err != nil {
+		return "", err
+	}
+
+	var respBody model.ResponseBody
+	if err := json.Unmarshal(b, &respBody); err != nil {
+		return "", fmt.Errorf("unreadable login answer (status %d) - %w", status, err)
+	}
+	// a failed login still has a body, with the reason in message and no token in data
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusNotFound:
+		logger.FromContext(ctx).Debug("Datastore refused login", "status", status, "message", respBody.Message)
+		return "", ErrInvalidCredentials
+	case status >= 400 || respBody.Status != "success":
+		return "", fmt.Errorf("datastore refused login (status %d) - %s", status, respBody.Message)
+	case respBody.Data == "":
+		return "", errors.New("datastore login answer has no token")
+	}
+	return respBody.Data, nil
+}
+
+// Swaps token for a new one at /auth/refresh. Datastores from before it answer 404, their tokens aren't renewed.
+func (s *HTTPStore) RenewToken(ctx context.Context, token string) (string, error) {
+	b, status, err := s.do(ctx, s.login, http.MethodPost, "/auth/refresh", token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		return "", ErrRenewUnsupported
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "", ErrInvalidCredentials
+	}
+	var respBody model.ResponseBody
+	if err := json.Unmarshal(b, &respBody); err != nil {
+		return "", fmt.Errorf("unreadable refresh answer (status %d) - %w", status, err)
+	}
+	if status >= 400 || respBody.Status != "success" || respBody.Data == "" {
+		return "", fmt.Errorf("datastore refused refresh (status %d) - %s", status, respBody.Message)
+	}
+	return respBody.Data, nil
+}
+
+// Recently confirmed tokens skip the call. While the datastore is down, a token it said yes to
+// within authGrace is still let in, so staff can keep recording sales into the outbox.
+func (s *HTTPStore) CheckAuth(ctx context.Context, token string) (bool, error) {
+	if token == "" {
+		return false, nil
+	}
+
+	s.authMu.Lock()
+	seen, ok := s.authSeen[token]
+	s.authMu.Unlock()
+	if ok && time.Since(seen) < s.authTTL {
+		authChecks.Inc("cache")
+		return true, nil
+	}
+
+	b, _, err := s.do(ctx, s.authStatus, http.MethodGet, "/auth/sta", token, nil)
+	if err != nil {
+		var unavailable *UnavailableError
+		if ok && errors.As(err, &unavailable) && time.Since(seen) < s.authGrace {
+			logger.FromContext(ctx).Warn("Datastore unavailable, trusting token confirmed earlier", "confirmed_at", seen)
+			authChecks.Inc("grace")
+			return true, nil
+		}
+		authChecks.Inc("error")
+		return false, err
+	}
+
+	authChecks.Inc("datastore")
+	var respBody model.ResponseBody
+	if err := json.Unmarshal(b, &respBody); err != nil {
+		return false, err
+	}
+
+	// "Invalid or expired JWT" or anything else is a no
+	authenticated := respBody.Message == "authenticated"
+
+	s.authMu.Lock()
+	if authenticated {
+		s.authSeen[token] = time.Now()
+	} else {
+		delete(s.authSeen, token)
+	}
+	// drop tokens nobody has used for a while
+	for t, at := range s.authSeen {
+		if time.Since(at) > s.authGrace {
+			delete(s.authSeen, t)
+		}
+	}
+	s.authMu.Unlock()
+
+	return authenticated, nil
+}
+
+func (s *HTTPStore) CreateSale(ctx context.Context, token string, sale *model.JsonSale) error {
+	path := "/sa/new/" +
+		strconv.FormatFloat(float64(sale.Amount), 'f', -1, 32) + "-" +
+		strconv.FormatFloat(float64(sale.Qty), 'f', -1, 32) + "-" +
+		strconv.Itoa(sale.PaymentType) + "-" +
+		strconv.Itoa(sale.OperationID) + "-" +
+		strconv.Itoa(sale.ItemID) + "-" +
+		strconv.Itoa(sale.GroupSaleID)
+
+	// an outbox sale carries when it was queued. A datastore that doesn't read created_at stamps the sale
+	// when it's inserted instead, the rollups go by what it has when they re-read that day.
+	if !sale.CreatedAt.IsZero() {
+		path += "?created_at=" + url.QueryEscape(sale.CreatedAt.UTC().Format(time.RFC3339))
+	}
+
+	_, status, err := s.do(ctx, s.newSale, http.MethodPost, path, token, nil)
+	if err != nil {
+		return err
+	}
+	if status >= 400 {
+		return fmt.Errorf("datastore returned %d", status)
+	}
+	if sale.CreatedAt.IsZero() {
+		sale.CreatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *HTTPStore) FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error) {
+	path := "/sa/find/"
+	if start != "" || end != "" {
+		path += url.PathEscape(start) + "-" + url.PathEscape(end)
+	}
+
+	b, status, err := s.do(ctx, s.findSales, http.MethodGet, path, token, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status >= 400 {
+		return nil, fmt.Errorf("datastore returned %d", status)
+	}
+
+	var sales []model.JsonSale
+	if err := json.Unmarshal(b, &sales); err != nil {
+		return nil, err
+	}
+	return sales, nil
+}
+
+// The datastore creates the account, role and the forced password change are kept in the users file
+func (s *HTTPStore) CreateUser(ctx context.Context, token string, by string, user *model.User, password string) error {
+	body, err := json.Marshal(map[string]string{"identity": user.Identity, "password": password})
+	if err != nil {
+		return err
+	}
+	if err := s.userCall(ctx, "/auth/register", token, body); err != nil {
+		return err
+	}
+
+	user.MustChangePassword = true
+	user.CreatedAt = time.Now()
+	user.CreatedBy = by
+	return s.update(user.Identity, by, true, func(u *model.User) error {
+		*u = *user
+		return nil
+	})
+}
+
+// The datastore doesn't know about disabled accounts, the app turns them away at login
+func (s *HTTPStore) SetUserDisabled(ctx context.Context, token string, by string, identity string, disabled bool) error {
+	return s.update(identity, by, false, func(u *model.User) error {
+		u.Disabled = disabled
+		return nil
+	})
+}
+
+// The datastore knows the identity once it takes the reset, an account from before the users file is added as a viewer
+func (s *HTTPStore) ResetPassword(ctx context.Context, token string, by string, identity string, password string) error {
+	body, err := json.Marshal(map[string]string{"identity": identity, "new_password": password})
+	if err != nil {
+		return err
+	}
+	if err := s.userCall(ctx, "/auth/password/reset", token, body); err != nil {
+		return err
+	}
+	return s.update(identity, by, true, func(u *model.User) error {
+		u.MustChangePassword = true
+		return nil
+	})
+}
+
+func (s *HTTPStore) ChangePassword(ctx context.Context, token string, identity string, current string, password string) error {
+	body, err := json.Marshal(map[string]string{"password": current, "new_password": password})
+	if err != nil {
+		return err
+	}
+	if err := s.userCall(ctx, "/auth/password", token, body); err != nil {
+		return err
+	}
+
+	u, err := s.User(identity)
+	if err != nil || !u.MustChangePassword {
+		return err
+	}
+	return s.update(identity, "", false, func(u *model.User) error {
+		u.MustChangePassword = false
+		return nil
+	})
+}
+
+// POSTs body to one of the datastore's user endpoints and turns its answer into one of the store errors
+func (s *HTTPStore) userCall(ctx context.Context, path string, token string, body []byte) error {
+	b, status, err := s.do(ctx, s.users, http.MethodPost, path, token, body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status < 400:
+		return nil
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		return ErrUsersUnsupported
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrInvalidCredentials
+	case status == http.StatusConflict:
+		return ErrUserExists
+	}
+
+	var respBody model.ResponseBody
+	if json.Unmarshal(b, &respBody) == nil && respBody.Message != "" {
+		return errors.New(respBody.Message)
+	}
+	return fmt.Errorf("datastore returned %d", status)
+}
+
+func (s *HTTPStore) Health() Health {
+	h := s.breaker.Health()
+	h.Kind = "http"
+	return h
+}
+
+// Any answer from the datastore counts, even "Invalid or expired JWT" for the missing token
+func (s *HTTPStore) Ping(ctx context.Context) error {
+	_, _, err := s.do(ctx, s.ping, http.MethodGet, "/auth/sta", "", nil)
+	return err
+}
+
+func (s *HTTPStore) Close() error {
+	return nil
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func testHTTPStore(t *testing.T, handler http.HandlerFunc) *HTTPStore {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewHTTPStore(&config.Config{
+		APIServerAddr: srv.URL,
+		Datastore: config.Datastore{
+			TimeoutFind: time.Second, TimeoutAuth: time.Second, TimeoutSale: time.Second, BreakerFailures: 100, BreakerCooldown: time.Second,
+		},
+	})
+}
+
+// The dates are one path segment on the datastore, whatever they hold
+func TestFindSalesPath(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       string
+	}{
+		{"", "", "/sa/find/"},
+		{"2026-01-01", "2026-01-31", "/sa/find/2026-01-01-2026-01-31"},
+		{"../../auth/sta", "", "/sa/find/..%2F..%2Fauth%2Fsta-"},
+		{"2026-01-01?x=", "#y", "/sa/find/2026-01-01%3Fx=-%23y"},
+	}
+	for _, tt := range tests {
+		var got string
+		s := testHTTPStore(t, func(w http.ResponseWriter, r *http.Request) {
+			got = r.URL.EscapedPath()
+			if r.URL.RawQuery != "" {
+				got += "?" + r.URL.RawQuery
+			}
+			w.Write([]byte("[]"))
+		})
+		if _, err := s.FindSales(context.Background(), "token", tt.start, tt.end); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("FindSales(%q, %q) asked for %s, want %s", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+// A queued sale goes with the time it was made, a new one is stamped by the datastore
+func TestCreateSaleTime(t *testing.T) {
+	queued := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		wantQuery string
+	}{
+		{"new sale", time.Time{}, ""},
+		{"queued sale", queued, "created_at=2026-03-14T09%3A30%3A00Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			s := testHTTPStore(t, func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.RawQuery
+			})
+			sale := &model.JsonSale{Amount: 8, Qty: 1, PaymentType: 1, OperationID: 1, ItemID: 1, CreatedAt: tt.createdAt}
+			if err := s.CreateSale(context.Background(), "token", sale); err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.wantQuery {
+				t.Errorf("query = %q, want %q", query, tt.wantQuery)
+			}
+			if !tt.createdAt.IsZero() && !sale.CreatedAt.Equal(tt.createdAt) {
+				t.Errorf("CreatedAt = %v, want it kept at %v", sale.CreatedAt, tt.createdAt)
+			}
+			if sale.CreatedAt.IsZero() {
+				t.Error("CreatedAt not set")
+			}
+		})
+	}
+}
+
+// A caller that gives up isn't kept waiting for the retries
+func TestRetryBackoffCanceled(t *testing.T) {
+	calls := 0
+	s := testHTTPStore(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	s.retries = 3
+	s.retryDelay = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, err := s.FindSales(ctx, "token", "", "")
+
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Errorf("err = %v, want *UnavailableError", err)
+	}
+	if took := time.Since(started); took > time.Second {
+		t.Errorf("FindSales took %v after the caller gave up", took)
+	}
+	if calls != 1 {
+		t.Errorf("datastore called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,131 @@
+// Package store is where the app keeps its data. Either the mims-datastore API server (http)
+// or a BoltDB file on this machine (embedded), picked with STORE in the config.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CRTOsp3ck/mims-app/config"
+	"github.com/CRTOsp3ck/mims-app/logger"
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("Invalid identity or password")
+	ErrUserExists         = errors.New("There is already a user with that identity")
+	ErrUserNotFound       = errors.New("No such user")
+	ErrUserDisabled       = errors.New("This account is disabled")
+	// the datastore answered 404 on its user endpoints, it's older than user management
+	ErrUsersUnsupported = errors.New("The datastore doesn't support managing users")
+	// the datastore answered 404 on /auth/refresh, its tokens last as long as it says (DATASTORE_TOKEN_LENGTH)
+	ErrRenewUnsupported = errors.New("The datastore can't renew login tokens")
+)
+
+// Returned when the datastore couldn't be reached or failed on its side. Sent tells if the request
+// may have gone through anyway (timeout, 5xx), so sending it again could double up.
+type UnavailableError struct {
+	Err  error
+	Sent bool
+}
+
+func (e *UnavailableError) Error() string {
+	return "Datastore unavailable - " + e.Err.Error()
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// Calls that may go to the datastore take a context, its request ID (see logger.WithRequestID) is sent along
+type Store interface {
+	// returns the token to keep in the cookie
+	Login(ctx context.Context, identity string, password string) (string, error)
+	CheckAuth(ctx context.Context, token string) (bool, error)
+	// a new token with a full lifetime for the login behind token (which stops working), when a "remember me"
+	// login is restored. ErrRenewUnsupported when the store can't, token is then used until it expires.
+	RenewToken(ctx context.Context, token string) (string, error)
+
+	CreateSale(ctx context.Context, token string, sale *model.JsonSale) error
+	// start and end are YYYY-MM-DD (both included), leave both empty for every sale ever made
+	FindSales(ctx context.Context, token string, start string, end string) ([]model.JsonSale, error)
+
+	// the account behind identity, Role is one of the model.Role* values
+	User(identity string) (*model.User, error)
+	ListUsers() ([]*model.User, error)
+	// by is the owner doing it. password is a temporary one, the user has to change it on first login.
+	CreateUser(ctx context.Context, token string, by string, user *model.User, password string) error
+	SetUserDisabled(ctx context.Context, token string, by string, identity string, disabled bool) error
+	// sets a temporary password, changed on next login like a new account's
+	ResetPassword(ctx context.Context, token string, by string, identity string, password string) error
+	// the user changing their own password, current has to match
+	ChangePassword(ctx context.Context, token string, identity string, current string, password string) error
+
+	ListTargets() ([]*model.Target, error)
+	CreateTarget(t *model.Target) error
+	DeleteTarget(id int) error
+
+	// for the health endpoint and the "datastore unavailable" banner
+	Health() Health
+	// checks the store can be reached right now, for the readiness endpoint
+	Ping(ctx context.Context) error
+
+	Close() error
+}
+
+// State of the store as seen from the app
+type Health struct {
+	Kind      string `json:"kind"`
+	Available bool   `json:"available"`
+	// circuit breaker in front of the datastore, always closed for the embedded store
+	Breaker  string     `json:"breaker"`
+	Failures int        `json:"consecutive_failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+var current Store
+
+// Opens the store picked in the config, call once at startup
+func Init(cfg *config.Config) error {
+	var s Store
+	var err error
+
+	switch cfg.Store {
+	case "http":
+		s = NewHTTPStore(cfg)
+	case "embedded":
+		// tokens last as long as a remembered login can
+		s, err = NewEmbeddedStore(cfg.StorePath, cfg.RememberMeLength, cfg.EmbeddedAdminIdentity, cfg.EmbeddedAdminPassword)
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("Unknown STORE, use http or embedded")
+	}
+
+	logger.Info("Using store", "kind", cfg.Store)
+
+	// SALES_CACHE_TTL=0 turns the sales cache off
+	if cfg.SalesCacheTTL > 0 {
+		s = newCachedStore(s, cfg.SalesCacheTTL)
+	}
+	// OnSaleCreated hooks, with or without the cache
+	s = newHookedStore(s)
+
+	current = s
+	return nil
+}
+
+func Current() Store {
+	return current
+}
+
+// Sales and time ranges are matched by date in local time, same as the datastore
+func inRange(sale model.JsonSale, start string, end string) bool {
+	if start == "" && end == "" {
+		return true
+	}
+	date := sale.CreatedAt.Local().Format("2006-01-02")
+	return date >= start && date <= end
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// The datastore has no targets yet, so in http mode they live in a json file next to the app
+type targetsFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *targetsFile) ListTargets() ([]*model.Target, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.load()
+}
+
+func (f *targetsFile) CreateTarget(t *model.Target) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	targets, err := f.load()
+	if err != nil {
+		return err
+	}
+	t.ID = nextTargetID(targets)
+	return f.save(append(targets, t))
+}
+
+func (f *targetsFile) DeleteTarget(id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	targets, err := f.load()
+	if err != nil {
+		return err
+	}
+	kept := []*model.Target{}
+	for _, t := range targets {
+		if t.ID != id {
+			kept = append(kept, t)
+		}
+	}
+	return f.save(kept)
+}
+
+func (f *targetsFile) load() ([]*model.Target, error) {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*model.Target{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []*model.Target{}
+	if err := json.Unmarshal(b, &targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+func (f *targetsFile) save(targets []*model.Target) error {
+	b, err := json.MarshalIndent(targets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	// write then rename so a crash never leaves half a file behind
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func nextTargetID(targets []*model.Target) int {
+	id := 1
+	for _, t := range targets {
+		if t.ID >= id {
+			id = t.ID + 1
+		}
+	}
+	return id
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+// The datastore keeps the passwords but nothing else about users, so in http mode roles, disabled
+// accounts and pending password changes live in a json file next to the app
+// ({"identity": {"role": "cashier", ...}}, the older {"identity": "role"} still reads).
+// Until anyone is listed everyone is an owner, as it was before roles; after that, anyone not listed is a viewer.
+type usersFile struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *usersFile) User(identity string) (*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return lookupUser(users, identity), nil
+}
+
+func (f *usersFile) ListUsers() ([]*model.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*model.User, 0, len(users))
+	for _, u := range users {
+		list = append(list, u)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Identity < list[j].Identity })
+	return list, nil
+}
+
+// Changes identity's entry with fn. An identity that isn't listed is ErrUserNotFound, unless add is set
+// (the datastore just took it) and it goes in as a viewer for fn to change. When nobody is listed yet,
+// by goes in as an owner first, otherwise the owner setting up the first account would become a viewer.
+func (f *usersFile) update(identity string, by string, add bool, fn func(u *model.User) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	users, err := f.load()
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 && by != "" && by != identity {
+		users[by] = &model.User{Identity: by, Role: model.RoleOwner}
+	}
+	u, ok := users[identity]
+	if !ok {
+		if !add {
+			return ErrUserNotFound
+		}
+		u = &model.User{Identity: identity, Role: model.RoleViewer}
+	}
+	if err := fn(u); err != nil {
+		return err
+	}
+	users[identity] = u
+	return f.save(users)
+}
+
+// identity's entry, or what someone who isn't listed gets
+func lookupUser(users map[string]*model.User, identity string) *model.User {
+	if u, ok := users[identity]; ok {
+		return u
+	}
+	role := model.RoleViewer
+	if len(users) == 0 {
+		role = model.RoleOwner
+	}
+	return &model.User{Identity: identity, Role: role}
+}
+
+func (f *usersFile) load() (map[string]*model.User, error) {
+	b, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*model.User{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	users := map[string]*model.User{}
+	for identity, entry := range entries {
+		u := &model.User{}
+		// just a role, from before the file knew anything else
+		if err := json.Unmarshal(entry, &u.Role); err != nil {
+			if err := json.Unmarshal(entry, u); err != nil {
+				return nil, err
+			}
+		}
+		u.Identity = identity
+		users[identity] = u
+	}
+	return users, nil
+}
+
+func (f *usersFile) save(users map[string]*model.User) error {
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	// write then rename so a crash never leaves half a file behind
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CRTOsp3ck/mims-app/model"
+)
+
+func TestUsersFileUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string // roles file before, empty for none
+		identity string
+		add      bool
+		fn       func(u *model.User)
+		wantErr  error
+		// every entry after, nil when the file isn't written
+		want map[string]string
+	}{
+		{
+			name:     "disable on an empty file",
+			identity: "ann", fn: func(u *model.User) { u.Disabled = true },
+			wantErr: ErrUserNotFound,
+		},
+		{
+			name:     "reset on an empty file",
+			identity: "ann", add: true, fn: func(u *model.User) { u.MustChangePassword = true },
+			want: map[string]string{"boss": model.RoleOwner, "ann": model.RoleViewer},
+		},
+		{
+			name:     "new account on an empty file",
+			identity: "ann", add: true, fn: func(u *model.User) { u.Role = model.RoleCashier },
+			want: map[string]string{"boss": model.RoleOwner, "ann": model.RoleCashier},
+		},
+		{
+			name:     "disable someone listed",
+			file:     `{"boss": "owner", "ann": "cashier"}`,
+			identity: "ann", fn: func(u *model.User) { u.Disabled = true },
+			want: map[string]string{"boss": model.RoleOwner, "ann": model.RoleCashier},
+		},
+		{
+			name:     "disable someone who isn't listed",
+			file:     `{"boss": "owner"}`,
+			identity: "nobody", fn: func(u *model.User) { u.Disabled = true },
+			wantErr: ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "roles.json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := &usersFile{path: path}
+
+			err := f.update(tt.identity, "boss", tt.add, func(u *model.User) error {
+				tt.fn(u)
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("update = %v, want %v", err, tt.wantErr)
+			}
+
+			users, err := f.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if tt.file == "" && len(users) != 0 {
+					t.Errorf("file written: %v", users)
+				}
+				return
+			}
+			if len(users) != len(tt.want) {
+				t.Errorf("users = %v, want %v", users, tt.want)
+			}
+			for identity, role := range tt.want {
+				if u := users[identity]; u == nil || u.Role != role {
+					t.Errorf("%s = %+v, want role %s", identity, u, role)
+				}
+			}
+		})
+	}
+}
+
+// Until anyone is listed everyone is an owner, after that anyone not listed is a viewer
+func TestUsersFileUser(t *testing.T) {
+	f := &usersFile{path: filepath.Join(t.TempDir(), "roles.json")}
+	for _, identity := range []string{"boss", "ann"} {
+		if u, _ := f.User(identity); u.Role != model.RoleOwner {
+			t.Errorf("%s on an empty file = %s, want owner", identity, u.Role)
+		}
+	}
+
+	if err := f.update("ann", "boss", true, func(u *model.User) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"boss": model.RoleOwner, "ann": model.RoleViewer, "carl": model.RoleViewer}
+	for identity, role := range want {
+		if u, _ := f.User(identity); u.Role != role {
+			t.Errorf("%s = %s, want %s", identity, u.Role, role)
+		}
+	}
+}